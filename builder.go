@@ -0,0 +1,140 @@
+package rheos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrUnbuffered is returned by Builder when a parallel step has an unbuffered output.
+	ErrUnbuffered = errors.New("parallel step output is not buffered")
+	// ErrInvalidWorkers is returned by Builder when a parallel step has less than one goroutine.
+	ErrInvalidWorkers = errors.New("number of goroutines must be positive")
+)
+
+// BuildError is returned by Builder when a step is likely misconfigured.
+type BuildError struct {
+	// Stage is a zero-based position of the step in the Builder.
+	Stage int
+	// Step is the name of the step, e.g. "ParMap".
+	Step string
+	// Err is the configuration problem.
+	Err error
+}
+
+func (e *BuildError) Error() string {
+	return fmt.Sprintf("stage %d (%s): %s", e.Stage, e.Step, e.Err)
+}
+
+func (e *BuildError) Unwrap() error {
+	return e.Err
+}
+
+// Builder accumulates steps of a pipeline which do not change the type of the elements
+// and validates their configuration before running them.
+// It reports configurations which are likely wrong, like parallel steps without a buffered output,
+// which the functions like ParMap do not enforce.
+type Builder[I any] struct {
+	stages []stage[I]
+}
+
+type stage[I any] struct {
+	name  string
+	apply func(Stream[I]) Stream[I]
+	check func(context.Context) error // returns configuration problem in the pipeline with the context
+}
+
+// NewBuilder creates an empty Builder.
+func NewBuilder[I any]() *Builder[I] {
+	return &Builder[I]{}
+}
+
+// Map adds a Map step.
+func (b *Builder[I]) Map(mapper func(context.Context, I) (I, error), ops ...Option[I]) *Builder[I] {
+	return b.add("Map", nil, func(pipe Stream[I]) Stream[I] {
+		return Map(pipe, mapper, ops...)
+	})
+}
+
+// Filter adds a Filter step.
+func (b *Builder[I]) Filter(callback func(context.Context, I) (bool, error), ops ...Option[I]) *Builder[I] {
+	return b.add("Filter", nil, func(pipe Stream[I]) Stream[I] {
+		return Filter(pipe, callback, ops...)
+	})
+}
+
+// ParMap adds a ParMap step. It requires a positive num and a buffered output,
+// set with WithBuffer or with WithDefaultBuffer of the pipeline.
+func (b *Builder[I]) ParMap(num int, mapper func(context.Context, I) (I, error), ops ...Option[I]) *Builder[I] {
+	return b.add("ParMap", checkParallel(num, ops), func(pipe Stream[I]) Stream[I] {
+		return ParMap(pipe, num, mapper, ops...)
+	})
+}
+
+// ParFilter adds a ParFilter step. It requires a positive num and a buffered output,
+// set with WithBuffer or with WithDefaultBuffer of the pipeline.
+func (b *Builder[I]) ParFilter(num int, callback func(context.Context, I) (bool, error), ops ...Option[I]) *Builder[I] {
+	return b.add("ParFilter", checkParallel(num, ops), func(pipe Stream[I]) Stream[I] {
+		return ParFilter(pipe, num, callback, ops...)
+	})
+}
+
+// Then adds a custom step, which is not validated.
+func (b *Builder[I]) Then(fn func(Stream[I]) Stream[I]) *Builder[I] {
+	return b.add("Then", nil, fn)
+}
+
+// Validate returns *BuildError for the first misconfigured step, or nil if all steps are valid.
+// It does not know the pipeline the steps are applied to, so it does not take into account
+// its default buffer set with WithDefaultBuffer, unlike Build.
+func (b *Builder[I]) Validate() error {
+	return b.validate(context.Background())
+}
+
+// Build validates the steps and applies them to pipe.
+// If any step is misconfigured, Build returns *BuildError without starting any steps,
+// pipe is left as is and should be consumed or its context cancelled by the caller.
+func (b *Builder[I]) Build(pipe Stream[I]) (Stream[I], error) {
+	if err := b.validate(pipe.ctx); err != nil {
+		return pipe, err
+	}
+
+	for _, s := range b.stages {
+		pipe = s.apply(pipe)
+	}
+
+	return pipe, nil
+}
+
+func (b *Builder[I]) validate(ctx context.Context) error {
+	for i, s := range b.stages {
+		if s.check == nil {
+			continue
+		}
+		if err := s.check(ctx); err != nil {
+			return &BuildError{Stage: i, Step: s.name, Err: err}
+		}
+	}
+
+	return nil
+}
+
+func (b *Builder[I]) add(name string, check func(context.Context) error, apply func(Stream[I]) Stream[I]) *Builder[I] {
+	b.stages = append(b.stages, stage[I]{name: name, apply: apply, check: check})
+
+	return b
+}
+
+func checkParallel[I any](num int, ops []Option[I]) func(context.Context) error {
+	return func(ctx context.Context) error {
+		if num < 1 {
+			return ErrInvalidWorkers
+		}
+		if applyOptions(ops).buffer(ctx) < 1 {
+			return ErrUnbuffered
+		}
+
+		return nil
+	}
+}
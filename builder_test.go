@@ -0,0 +1,77 @@
+package rheos_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/dmksnnk/rheos"
+)
+
+func TestBuilder(t *testing.T) {
+	double := func(_ context.Context, v int) (int, error) { return v * 2, nil }
+	even := func(_ context.Context, v int) (bool, error) { return v%2 == 0, nil }
+
+	t.Run("build", func(t *testing.T) {
+		builder := rheos.NewBuilder[int]().
+			Filter(even).
+			ParMap(3, double, rheos.WithBuffer[int](3)).
+			Then(func(pipe rheos.Stream[int]) rheos.Stream[int] {
+				return rheos.Map(pipe, double)
+			})
+
+		pipe, err := builder.Build(newProducer(context.Background(), 10))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, err := rheos.Collect(pipe)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		sort.Ints(got)
+		assertSlicesEqual(t, []int{0, 8, 16, 24, 32}, got)
+	})
+
+	t.Run("unbuffered parallel step", func(t *testing.T) {
+		builder := rheos.NewBuilder[int]().
+			Map(double).
+			ParFilter(3, even)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		_, err := builder.Build(newProducer(ctx, 10))
+		if !errors.Is(err, rheos.ErrUnbuffered) {
+			t.Fatalf("unexpected error: %v, want: %v", err, rheos.ErrUnbuffered)
+		}
+		var buildErr *rheos.BuildError
+		if !errors.As(err, &buildErr) {
+			t.Fatalf("unexpected error: %v, want BuildError", err)
+		}
+		if buildErr.Stage != 1 || buildErr.Step != "ParFilter" {
+			t.Errorf("got stage %d (%s), want stage 1 (ParFilter)", buildErr.Stage, buildErr.Step)
+		}
+	})
+
+	t.Run("default buffer", func(t *testing.T) {
+		builder := rheos.NewBuilder[int]().ParMap(3, double)
+
+		pipe, err := builder.Build(newProducer(rheos.WithDefaultBuffer(context.Background(), 3), 10))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, err := rheos.Collect(pipe)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		sort.Ints(got)
+		assertSlicesEqual(t, []int{0, 2, 4, 6, 8, 10, 12, 14, 16, 18}, got)
+	})
+
+	t.Run("no goroutines", func(t *testing.T) {
+		err := rheos.NewBuilder[int]().ParMap(0, double, rheos.WithBuffer[int](1)).Validate()
+		if !errors.Is(err, rheos.ErrInvalidWorkers) {
+			t.Errorf("unexpected error: %v, want: %v", err, rheos.ErrInvalidWorkers)
+		}
+	})
+}
@@ -0,0 +1,25 @@
+//go:build go1.20
+
+package rheos_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dmksnnk/rheos"
+)
+
+func TestUnitPipelineErrorCause(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(errTest)
+
+	_, err := rheos.Collect(newProducer(ctx, 10))
+	var pErr *rheos.PipelineError
+	if !errors.As(err, &pErr) {
+		t.Fatalf("unexpected error: %v, want PipelineError", err)
+	}
+	if !errors.Is(pErr.Cause, errTest) {
+		t.Errorf("unexpected cause: %v, want: %v", pErr.Cause, errTest)
+	}
+}
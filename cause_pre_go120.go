@@ -0,0 +1,9 @@
+//go:build !go1.20
+
+package rheos
+
+import "context"
+
+func cause(ctx context.Context) error {
+	return ctx.Err()
+}
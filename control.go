@@ -0,0 +1,182 @@
+package rheos
+
+import (
+	"context"
+	"sync"
+)
+
+// Controller allows to quiesce a pipeline: stop admitting new elements,
+// wait until in-flight elements are processed and then resume processing.
+// It is created with Controlled and elements are marked as processed with Commit.
+type Controller struct {
+	ctx context.Context // pipeline context
+
+	mu        sync.Mutex
+	paused    bool
+	resumed   chan struct{} // closed on Resume
+	drained   chan struct{} // closed when all admitted elements are committed while paused
+	admitted  int64
+	committed int64
+}
+
+// Controlled returns a Stream, which elements can be paused by the returned Controller.
+// It is intended to be placed right after the source. Each element passed through Controlled
+// must reach Commit with the same Controller, so stages between them must not drop elements
+// (like Filter does) or emit new ones (like UnBatch does), otherwise Quiesce never observes the pipeline as drained.
+// If context is cancelled during processing, Controlled stops processing and returns error.
+func Controlled[I any](pipe Stream[I], ops ...Option[I]) (Stream[I], *Controller) {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	ctl := &Controller{ctx: pipe.ctx}
+
+	goStep(pipe.eg, pipe.ctx, "Controlled", applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		for elem := range pipe.in {
+			if err := ctl.admit(pipe.ctx); err != nil {
+				return err
+			}
+
+			if err := emit(pipe.ctx, stopper, output, elem); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return Stream[I]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}, ctl
+}
+
+// Commit marks elements as processed for ctl, when they reach it.
+// Place it after the last step with side effects, which results should be covered by a checkpoint.
+// If context is cancelled during processing, Commit stops processing and returns error.
+func Commit[I any](pipe Stream[I], ctl *Controller, ops ...Option[I]) Stream[I] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, "Commit", applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		for elem := range pipe.in {
+			ctl.commit()
+
+			if err := emit(pipe.ctx, stopper, output, elem); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return Stream[I]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// Quiesce stops admitting new elements and waits until all admitted elements are committed.
+// It returns a checkpoint: the number of elements committed, which is the offset of the next element
+// to process after a restart. Pipeline stays paused until Resume is called.
+// If ctx or the pipeline context is done before the pipeline is drained,
+// Quiesce returns the number of elements committed so far and the context error.
+func (c *Controller) Quiesce(ctx context.Context) (int64, error) {
+	c.mu.Lock()
+	if !c.paused {
+		c.paused = true
+		c.resumed = make(chan struct{})
+		c.drained = make(chan struct{})
+		c.checkDrained()
+	}
+	drained := c.drained
+	c.mu.Unlock()
+
+	select {
+	case <-drained:
+		return c.checkpoint(), nil
+	default:
+	}
+
+	select {
+	case <-drained:
+		return c.checkpoint(), nil
+	case <-ctx.Done():
+		return c.checkpoint(), ctx.Err()
+	case <-c.ctx.Done():
+		return c.checkpoint(), c.ctx.Err()
+	}
+}
+
+// Resume resumes admitting new elements after Quiesce. It is a no-op if the pipeline is not paused.
+func (c *Controller) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.paused {
+		return
+	}
+
+	c.paused = false
+	close(c.resumed)
+}
+
+func (c *Controller) admit(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		if !c.paused {
+			c.admitted++
+			c.mu.Unlock()
+
+			return nil
+		}
+		resumed := c.resumed
+		c.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-resumed:
+		}
+	}
+}
+
+func (c *Controller) commit() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.committed++
+	if c.paused {
+		c.checkDrained()
+	}
+}
+
+// checkDrained closes drained channel if all admitted elements are committed.
+// Must be called with c.mu held.
+func (c *Controller) checkDrained() {
+	if c.committed < c.admitted {
+		return
+	}
+
+	select {
+	case <-c.drained:
+	default:
+		close(c.drained)
+	}
+}
+
+func (c *Controller) checkpoint() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.committed
+}
@@ -0,0 +1,89 @@
+package rheos_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dmksnnk/rheos"
+)
+
+func TestController(t *testing.T) {
+	t.Run("quiesce and resume", func(t *testing.T) {
+		num := 100
+		var processed int64
+
+		src, ctl := rheos.Controlled(newProducer(context.Background(), num))
+		mapped := rheos.Map(src, func(_ context.Context, v int) (int, error) {
+			time.Sleep(time.Millisecond) // simulate work
+			atomic.AddInt64(&processed, 1)
+			return v, nil
+		})
+		committed := rheos.Commit(mapped, ctl)
+
+		done := make(chan error)
+		var got []int
+		go func() {
+			done <- rheos.ForEach(committed, func(_ context.Context, v int) error {
+				got = append(got, v)
+				return nil
+			})
+		}()
+
+		for atomic.LoadInt64(&processed) < 10 {
+			time.Sleep(time.Millisecond)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		checkpoint, err := ctl.Quiesce(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if p := atomic.LoadInt64(&processed); p != checkpoint {
+			t.Errorf("checkpoint %d, processed %d", checkpoint, p)
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		if p := atomic.LoadInt64(&processed); p != checkpoint {
+			t.Errorf("processed %d elements while paused, checkpoint %d", p, checkpoint)
+		}
+
+		ctl.Resume()
+		if err := <-done; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(num), got)
+	})
+
+	t.Run("quiesce timeout", func(t *testing.T) {
+		src, ctl := rheos.Controlled(newProducer(context.Background(), 10))
+		// elements are never committed
+		started := make(chan struct{}, 1)
+		done := make(chan error)
+		go func() {
+			done <- rheos.ForEach(src, func(_ context.Context, v int) error {
+				select {
+				case started <- struct{}{}:
+				default:
+				}
+				return nil
+			})
+		}()
+		<-started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := ctl.Quiesce(ctx)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.DeadlineExceeded)
+		}
+
+		ctl.Resume()
+		if err := <-done; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
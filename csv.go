@@ -0,0 +1,69 @@
+package rheos
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// FromCSV creates a new Stream of CSV records read from r, each record as a slice of fields.
+// Fields are separated by comma, unless another separator is set with WithComma.
+// The first record is skipped, if WithSkipHeader is set.
+// If reading fails or a record can't be parsed, Stream stops processing and returns error,
+// which is *csv.ParseError for parsing errors.
+// If context is cancelled during processing, Stream stops processing and returns error,
+// though a read which is already blocked on r is not interrupted.
+func FromCSV(ctx context.Context, r io.Reader, ops ...Option[[]string]) Stream[[]string] {
+	opts := applyOptions(ops, commaSetting, skipHeaderSetting)
+
+	return FromIter(ctx, func(yield func([]string) bool) error {
+		reader := csv.NewReader(r)
+		if opts.specific.comma != 0 {
+			reader.Comma = opts.specific.comma
+		}
+
+		for skip := opts.specific.skipHeader; ; skip = false {
+			record, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			if !skip && !yield(record) {
+				return nil
+			}
+		}
+	}, opts.option())
+}
+
+// WriteCSV writes each record of the stream to w as CSV. Fields are separated by comma,
+// e.g. '\t' or ';', zero means ','. Records are buffered and flushed to w when the stream ends,
+// even if processing fails.
+// If writing fails or context is cancelled during processing, WriteCSV stops and returns error.
+func WriteCSV(pipe Stream[[]string], w io.Writer, comma rune) error {
+	writer := csv.NewWriter(w)
+	if comma != 0 {
+		writer.Comma = comma
+	}
+
+	written := 0
+	err := ForEach(pipe, func(_ context.Context, record []string) error {
+		if err := writer.Write(record); err != nil {
+			return fmt.Errorf("write: %w", err)
+		}
+		written++
+
+		return nil
+	})
+
+	writer.Flush()
+	if flushErr := writer.Error(); flushErr != nil && err == nil {
+		err = newPipelineError(pipe.ctx, fmt.Errorf("flush: %w", flushErr), written)
+	}
+
+	return err
+}
@@ -0,0 +1,137 @@
+package rheos_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/dmksnnk/rheos"
+)
+
+func TestFromCSV(t *testing.T) {
+	t.Run("reads records", func(t *testing.T) {
+		input := strings.NewReader("id,name\n1,a\n2,\"b, c\"\n")
+
+		got, err := rheos.Collect(rheos.FromCSV(context.Background(), input))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertRecordsEqual(t, [][]string{{"id", "name"}, {"1", "a"}, {"2", "b, c"}}, got)
+	})
+
+	t.Run("comma and header", func(t *testing.T) {
+		input := strings.NewReader("id;name\n1;a\n2;b\n")
+
+		got, err := rheos.Collect(rheos.FromCSV(context.Background(), input, rheos.WithComma[[]string](';'), rheos.WithSkipHeader[[]string]()))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertRecordsEqual(t, [][]string{{"1", "a"}, {"2", "b"}}, got)
+	})
+
+	t.Run("only header", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.FromCSV(context.Background(), strings.NewReader("id,name\n"), rheos.WithSkipHeader[[]string]()))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != 0 {
+			t.Errorf("want empty result, got %v", got)
+		}
+	})
+
+	t.Run("parse error", func(t *testing.T) {
+		input := strings.NewReader("1,a\n2,\"b\n")
+
+		_, err := rheos.Collect(rheos.FromCSV(context.Background(), input))
+		var parseErr *csv.ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("unexpected error: %v, want ParseError", err)
+		}
+	})
+
+	t.Run("read error", func(t *testing.T) {
+		r := io.MultiReader(strings.NewReader("1,a\n"), iotest.ErrReader(errTest))
+
+		_, err := rheos.Collect(rheos.FromCSV(context.Background(), r))
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := rheos.Collect(rheos.FromCSV(ctx, strings.NewReader("1,a\n2,b\n")))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestWriteCSV(t *testing.T) {
+	records := [][]string{{"id", "name"}, {"1", "a"}, {"2", "b, c"}}
+
+	t.Run("writes records", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := rheos.WriteCSV(rheos.FromSlice(context.Background(), records), &buf, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got, want := buf.String(), "id,name\n1,a\n2,\"b, c\"\n"; got != want {
+			t.Errorf("written %q, want %q", got, want)
+		}
+	})
+
+	t.Run("round trip with comma", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := rheos.WriteCSV(rheos.FromSlice(context.Background(), records), &buf, '\t'); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		got, err := rheos.Collect(rheos.FromCSV(context.Background(), &buf, rheos.WithComma[[]string]('\t')))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertRecordsEqual(t, records, got)
+	})
+
+	t.Run("flushes on error", func(t *testing.T) {
+		failing := rheos.FromIter(context.Background(), func(yield func([]string) bool) error {
+			yield([]string{"1", "a"})
+			yield([]string{"2", "b"}) // received once the first record is written
+			return errTest
+		})
+
+		var buf bytes.Buffer
+		err := rheos.WriteCSV(failing, &buf, 0)
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+		if got := buf.String(); !strings.HasPrefix(got, "1,a\n") {
+			t.Errorf("written %q, want prefix %q", got, "1,a\n")
+		}
+	})
+
+	t.Run("write error", func(t *testing.T) {
+		err := rheos.WriteCSV(rheos.FromSlice(context.Background(), records), &failingWriteSeeker{failAt: 1}, 0)
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+}
+
+func assertRecordsEqual(t *testing.T, want, got [][]string) {
+	t.Helper()
+
+	if len(want) != len(got) {
+		t.Fatalf("got %d records, want %d", len(got), len(want))
+	}
+	for i := range want {
+		assertSlicesEqual(t, want[i], got[i])
+	}
+}
@@ -2,4 +2,9 @@ module github.com/dmksnnk/rheos
 
 go 1.18
 
-require golang.org/x/sync v0.7.0
+require (
+	go.opentelemetry.io/otel v1.14.0
+	go.opentelemetry.io/otel/trace v1.14.0
+	golang.org/x/sync v0.7.0
+	golang.org/x/time v0.5.0
+)
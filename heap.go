@@ -0,0 +1,50 @@
+package rheos
+
+import "container/heap"
+
+// lessHeap is a min-heap ordered by less function.
+type lessHeap[I any] struct {
+	items []I
+	less  func(I, I) bool
+}
+
+func newLessHeap[I any](less func(I, I) bool) *lessHeap[I] {
+	return &lessHeap[I]{less: less}
+}
+
+func (h *lessHeap[I]) Len() int { return len(h.items) }
+
+func (h *lessHeap[I]) Less(i, j int) bool { return h.less(h.items[i], h.items[j]) }
+
+func (h *lessHeap[I]) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *lessHeap[I]) Push(x any) {
+	h.items = append(h.items, x.(I))
+}
+
+func (h *lessHeap[I]) Pop() any {
+	last := h.items[len(h.items)-1]
+	var zero I
+	h.items[len(h.items)-1] = zero // do not hold a reference
+	h.items = h.items[:len(h.items)-1]
+
+	return last
+}
+
+func (h *lessHeap[I]) push(elem I) {
+	heap.Push(h, elem)
+}
+
+func (h *lessHeap[I]) pop() I {
+	return heap.Pop(h).(I)
+}
+
+func (h *lessHeap[I]) peek() I {
+	return h.items[0]
+}
+
+// replace replaces the smallest element with elem.
+func (h *lessHeap[I]) replace(elem I) {
+	h.items[0] = elem
+	heap.Fix(h, 0)
+}
@@ -0,0 +1,243 @@
+package rheos
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrInvalidChunkSize is returned by ContentDefinedChunk when the chunk sizes are not valid.
+var ErrInvalidChunkSize = errors.New("chunk sizes must satisfy 0 <= minSize <= maxSize and maxSize > 0")
+
+// FromReader creates a new Stream of lines read from r, without line endings.
+// Lines are limited to bufio.MaxScanTokenSize bytes, the limit can be changed with WithMaxLineSize.
+// If reading fails or a line is too long, Stream stops processing and returns error.
+// If context is cancelled during processing, Stream stops processing and returns error,
+// though a read which is already blocked on r is not interrupted.
+func FromReader(ctx context.Context, r io.Reader, ops ...Option[string]) Stream[string] {
+	opts := applyOptions(ops, maxLineSizeSetting)
+
+	return FromIter(ctx, func(yield func(string) bool) error {
+		scanner := bufio.NewScanner(r)
+		if size := opts.specific.maxLineSize; size > 0 {
+			scanner.Buffer(nil, size)
+		}
+
+		for scanner.Scan() {
+			if !yield(scanner.Text()) {
+				return nil
+			}
+		}
+
+		return scanner.Err()
+	}, opts.option())
+}
+
+// FromReaderDelim creates a new Stream of chunks read from r, split at delim. Chunks do not include delim.
+// The last chunk is emitted even if it is not followed by delim.
+// If reading fails, Stream stops processing and returns error.
+// If context is cancelled during processing, Stream stops processing and returns error,
+// though a read which is already blocked on r is not interrupted.
+func FromReaderDelim(ctx context.Context, r io.Reader, delim byte, ops ...Option[[]byte]) Stream[[]byte] {
+	return FromIter(ctx, func(yield func([]byte) bool) error {
+		reader := bufio.NewReader(r)
+		for {
+			chunk, err := reader.ReadBytes(delim)
+			if err != nil && !errors.Is(err, io.EOF) {
+				return err
+			}
+
+			if errors.Is(err, io.EOF) {
+				if len(chunk) > 0 {
+					yield(chunk)
+				}
+
+				return nil
+			}
+
+			if !yield(chunk[:len(chunk)-1]) {
+				return nil
+			}
+		}
+	}, ops...)
+}
+
+// WriteTo writes each element of the stream to w, formatted with format.
+// It returns the number of bytes written, including the bytes of a partial write which failed.
+// If format or writing fails or context is cancelled during processing, WriteTo stops and returns error.
+func WriteTo[I any](pipe Stream[I], w io.Writer, format func(I) ([]byte, error)) (int64, error) {
+	var written int64
+	err := ForEach(pipe, func(_ context.Context, elem I) error {
+		data, err := format(elem)
+		if err != nil {
+			return fmt.Errorf("format: %w", err)
+		}
+
+		n, err := w.Write(data)
+		written += int64(n)
+		if err != nil {
+			return fmt.Errorf("write: %w", err)
+		}
+
+		return nil
+	})
+
+	return written, err
+}
+
+// WriteLines writes each string of the stream to w as a line, followed by a newline.
+// It returns the number of bytes written, like WriteTo.
+func WriteLines(pipe Stream[string], w io.Writer) (int64, error) {
+	return WriteTo(pipe, w, func(line string) ([]byte, error) {
+		return append([]byte(line), '\n'), nil
+	})
+}
+
+// WritePartitioned writes each element of the stream to a writer selected by the element key.
+// Writers are opened with openFile on the first element of the key and are closed in the same order
+// when the stream ends, even if processing fails. Elements are encoded with encode.
+// If opening, writing or closing fails or context is cancelled during processing,
+// WritePartitioned stops and returns error.
+func WritePartitioned[I any, K comparable](pipe Stream[I], key func(I) K, openFile func(K) (io.WriteCloser, error), encode func(I) []byte) error {
+	writers := make(map[K]io.WriteCloser)
+	var opened []K // keys in order of opening, so writers are closed in a stable order
+
+	written := 0
+	err := ForEach(pipe, func(_ context.Context, elem I) error {
+		k := key(elem)
+		w, ok := writers[k]
+		if !ok {
+			var err error
+			if w, err = openFile(k); err != nil {
+				return fmt.Errorf("open partition %v: %w", k, err)
+			}
+			writers[k] = w
+			opened = append(opened, k)
+		}
+
+		if _, err := w.Write(encode(elem)); err != nil {
+			return fmt.Errorf("write partition %v: %w", k, err)
+		}
+		written++
+
+		return nil
+	})
+
+	for _, k := range opened {
+		if closeErr := writers[k].Close(); closeErr != nil && err == nil {
+			err = newPipelineError(pipe.ctx, fmt.Errorf("close partition %v: %w", k, closeErr), written)
+		}
+	}
+
+	return err
+}
+
+// TransferWithResume writes chunks of the stream to w, starting at resumeAt offset.
+// It returns the offset after the last successfully written chunk, so an interrupted transfer
+// can be resumed by calling TransferWithResume again with the returned offset and remaining chunks.
+// If seeking or writing fails or context is cancelled during processing,
+// TransferWithResume stops and returns the offset and error.
+func TransferWithResume(pipe Stream[[]byte], w io.WriteSeeker, resumeAt int64) (int64, error) {
+	offset, err := w.Seek(resumeAt, io.SeekStart)
+	if err != nil {
+		err = fmt.Errorf("seek: %w", err)
+		if pipeErr := ForEach(pipe, func(context.Context, []byte) error {
+			return err // stops the pipeline
+		}); pipeErr != nil {
+			return resumeAt, pipeErr
+		}
+
+		return resumeAt, newPipelineError(pipe.ctx, err, 0)
+	}
+
+	err = ForEach(pipe, func(_ context.Context, chunk []byte) error {
+		n, err := w.Write(chunk)
+		offset += int64(n)
+		if err != nil {
+			return fmt.Errorf("write at offset %d: %w", offset, err)
+		}
+
+		return nil
+	})
+
+	return offset, err
+}
+
+// ContentDefinedChunk re-chunks a stream of bytes at content-defined boundaries using a rolling gear hash,
+// similar to backup tools like restic. A boundary is placed after a byte where the hash masked by mask is zero,
+// so insertions or removals in the content shift only nearby boundaries, which makes chunks suitable for deduplication.
+// Each chunk is at least minSize and at most maxSize bytes, except for the last one, which is emitted when the stream ends.
+// The mask defines the average chunk size: a mask with n bits set gives chunks of about 2^n bytes after minSize.
+// Boundaries depend only on the content, not on how the input is split into slices.
+// If minSize is negative, maxSize is not positive or minSize is greater than maxSize,
+// ContentDefinedChunk stops processing and returns ErrInvalidChunkSize.
+// If context is cancelled during processing, ContentDefinedChunk stops processing and returns error.
+func ContentDefinedChunk(pipe Stream[[]byte], minSize, maxSize int, mask uint64, ops ...Option[[]byte]) Stream[[]byte] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	gear := gearTable()
+
+	goStep(pipe.eg, pipe.ctx, "ContentDefinedChunk", applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		if minSize < 0 || maxSize <= 0 || minSize > maxSize {
+			return ErrInvalidChunkSize
+		}
+
+		var hash uint64
+		chunk := make([]byte, 0, minSize)
+		for data := range pipe.in {
+			for _, b := range data {
+				chunk = append(chunk, b)
+				hash = (hash << 1) + gear[b]
+
+				if len(chunk) < minSize {
+					continue
+				}
+				if hash&mask != 0 && len(chunk) < maxSize {
+					continue
+				}
+
+				if err := emit(pipe.ctx, stopper, output, chunk); err != nil {
+					return err
+				}
+				chunk = make([]byte, 0, minSize)
+				hash = 0
+			}
+		}
+
+		if len(chunk) > 0 {
+			return emit(pipe.ctx, stopper, output, chunk)
+		}
+
+		return nil
+	})
+
+	return Stream[[]byte]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// gearTable returns a table of pseudo-random values for gear hash, generated with splitmix64.
+// The table must be the same between runs, so boundaries are stable.
+func gearTable() [256]uint64 {
+	var table [256]uint64
+
+	state := uint64(0x9E3779B97F4A7C15)
+	for i := range table {
+		state += 0x9E3779B97F4A7C15
+		z := state
+		z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+		z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+		table[i] = z ^ (z >> 31)
+	}
+
+	return table
+}
@@ -0,0 +1,387 @@
+package rheos_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/dmksnnk/rheos"
+)
+
+type testWriteCloser struct {
+	bytes.Buffer
+	closed   bool
+	closeErr error
+}
+
+func (w *testWriteCloser) Close() error {
+	w.closed = true
+	return w.closeErr
+}
+
+func TestFromReader(t *testing.T) {
+	t.Run("reads lines", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.FromReader(context.Background(), strings.NewReader("a\nbb\r\n\nccc")))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []string{"a", "bb", "", "ccc"}, got)
+	})
+
+	t.Run("line too long", func(t *testing.T) {
+		long := strings.Repeat("x", bufio.MaxScanTokenSize+1)
+		_, err := rheos.Collect(rheos.FromReader(context.Background(), strings.NewReader(long)))
+		if !errors.Is(err, bufio.ErrTooLong) {
+			t.Errorf("unexpected error: %v, want: %v", err, bufio.ErrTooLong)
+		}
+	})
+
+	t.Run("max line size", func(t *testing.T) {
+		long := strings.Repeat("x", bufio.MaxScanTokenSize+1)
+		stream := rheos.FromReader(context.Background(), strings.NewReader(long+"\ny"), rheos.WithMaxLineSize[string](2*bufio.MaxScanTokenSize))
+		got, err := rheos.Collect(stream)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []string{long, "y"}, got)
+	})
+
+	t.Run("read error", func(t *testing.T) {
+		r := io.MultiReader(strings.NewReader("a\n"), iotest.ErrReader(errTest))
+		_, err := rheos.Collect(rheos.FromReader(context.Background(), r))
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := rheos.Collect(rheos.FromReader(ctx, strings.NewReader("a\nb\n")))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestFromReaderDelim(t *testing.T) {
+	toStrings := func(_ context.Context, b []byte) (string, error) { return string(b), nil }
+
+	for name, tc := range map[string]struct {
+		input string
+		want  []string
+	}{
+		"trailing delimiter":    {input: "a\x00bb\x00", want: []string{"a", "bb"}},
+		"no trailing delimiter": {input: "a\x00bb\x00ccc", want: []string{"a", "bb", "ccc"}},
+		"empty chunks":          {input: "\x00a\x00\x00", want: []string{"", "a", ""}},
+		"empty input":           {input: "", want: []string{}},
+	} {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			chunks := rheos.FromReaderDelim(context.Background(), strings.NewReader(tc.input), 0)
+			got, err := rheos.Collect(rheos.Map(chunks, toStrings))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assertSlicesEqual(t, tc.want, got)
+		})
+	}
+
+	t.Run("read error", func(t *testing.T) {
+		r := io.MultiReader(strings.NewReader("a\x00b"), iotest.ErrReader(errTest))
+		_, err := rheos.Collect(rheos.FromReaderDelim(context.Background(), r, 0))
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+}
+
+func TestWritePartitioned(t *testing.T) {
+	encode := func(v int) []byte {
+		return []byte(strconv.Itoa(v) + "\n")
+	}
+	parity := func(v int) string {
+		if v%2 == 0 {
+			return "even"
+		}
+		return "odd"
+	}
+
+	t.Run("writes partitions", func(t *testing.T) {
+		files := make(map[string]*testWriteCloser)
+		open := func(k string) (io.WriteCloser, error) {
+			w := &testWriteCloser{}
+			files[k] = w
+			return w, nil
+		}
+
+		err := rheos.WritePartitioned(newProducer(context.Background(), 6), parity, open, encode)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(files) != 2 {
+			t.Fatalf("got %d partitions, want 2", len(files))
+		}
+		if got := files["even"].String(); got != "0\n2\n4\n" {
+			t.Errorf("even partition: %q", got)
+		}
+		if got := files["odd"].String(); got != "1\n3\n5\n" {
+			t.Errorf("odd partition: %q", got)
+		}
+		for k, f := range files {
+			if !f.closed {
+				t.Errorf("partition %s is not closed", k)
+			}
+		}
+	})
+
+	t.Run("open error closes opened", func(t *testing.T) {
+		files := make(map[string]*testWriteCloser)
+		open := func(k string) (io.WriteCloser, error) {
+			if k == "odd" {
+				return nil, errTest
+			}
+			w := &testWriteCloser{}
+			files[k] = w
+			return w, nil
+		}
+
+		err := rheos.WritePartitioned(newProducer(context.Background(), 6), parity, open, encode)
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+		if !files["even"].closed {
+			t.Error("opened partition is not closed")
+		}
+	})
+
+	t.Run("close error of first opened", func(t *testing.T) {
+		errOdd := errors.New("close odd")
+		for i := 0; i < 10; i++ { // closing in map order would report either error
+			open := func(k string) (io.WriteCloser, error) {
+				if k == "odd" {
+					return &testWriteCloser{closeErr: errOdd}, nil
+				}
+				return &testWriteCloser{closeErr: errTest}, nil
+			}
+
+			err := rheos.WritePartitioned(newProducer(context.Background(), 6), parity, open, encode)
+			if !errors.Is(err, errTest) {
+				t.Fatalf("unexpected error: %v, want: %v", err, errTest)
+			}
+			var pipeErr *rheos.PipelineError
+			if !errors.As(err, &pipeErr) {
+				t.Fatalf("unexpected error: %v, want PipelineError", err)
+			}
+			if pipeErr.Processed != 6 {
+				t.Errorf("got %d processed, want 6", pipeErr.Processed)
+			}
+		}
+	})
+}
+
+type failingWriteSeeker struct {
+	buf    []byte
+	pos    int64
+	failAt int64 // fail writes past this size
+}
+
+func (w *failingWriteSeeker) Seek(offset int64, whence int) (int64, error) {
+	if whence != io.SeekStart {
+		return 0, errTest
+	}
+	w.pos = offset
+	return offset, nil
+}
+
+func (w *failingWriteSeeker) Write(p []byte) (int, error) {
+	if w.failAt > 0 && w.pos+int64(len(p)) > w.failAt {
+		return 0, errTest
+	}
+	for int64(len(w.buf)) < w.pos+int64(len(p)) {
+		w.buf = append(w.buf, 0)
+	}
+	copy(w.buf[w.pos:], p)
+	w.pos += int64(len(p))
+	return len(p), nil
+}
+
+func TestTransferWithResume(t *testing.T) {
+	chunks := [][]byte{[]byte("abc"), []byte("def"), []byte("ghi")}
+
+	w := &failingWriteSeeker{failAt: 6}
+	offset, err := rheos.TransferWithResume(rheos.FromSlice(context.Background(), chunks), w, 0)
+	if !errors.Is(err, errTest) {
+		t.Fatalf("unexpected error: %v, want: %v", err, errTest)
+	}
+	if offset != 6 {
+		t.Fatalf("offset %d, want 6", offset)
+	}
+
+	// resume with remaining chunks
+	w.failAt = 0
+	offset, err = rheos.TransferWithResume(rheos.FromSlice(context.Background(), chunks[2:]), w, offset)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if offset != 9 {
+		t.Errorf("offset %d, want 9", offset)
+	}
+	if got := string(w.buf); got != "abcdefghi" {
+		t.Errorf("written %q, want %q", got, "abcdefghi")
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	format := func(v int) ([]byte, error) {
+		return []byte(strconv.Itoa(v) + ","), nil
+	}
+
+	t.Run("writes formatted elements", func(t *testing.T) {
+		var buf bytes.Buffer
+		n, err := rheos.WriteTo(newProducer(context.Background(), 5), &buf, format)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := buf.String(); got != "0,1,2,3,4," {
+			t.Errorf("written %q, want %q", got, "0,1,2,3,4,")
+		}
+		if n != 10 {
+			t.Errorf("written %d bytes, want 10", n)
+		}
+	})
+
+	t.Run("format error", func(t *testing.T) {
+		var buf bytes.Buffer
+		n, err := rheos.WriteTo(newProducer(context.Background(), 5), &buf, func(v int) ([]byte, error) {
+			if v == 2 {
+				return nil, errTest
+			}
+			return format(v)
+		})
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+		if n != 4 {
+			t.Errorf("written %d bytes, want 4", n)
+		}
+	})
+
+	t.Run("write error", func(t *testing.T) {
+		w := &failingWriteSeeker{failAt: 6}
+		n, err := rheos.WriteTo(newProducer(context.Background(), 5), w, format)
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+		if n != 6 {
+			t.Errorf("written %d bytes, want 6", n)
+		}
+	})
+
+	t.Run("lines", func(t *testing.T) {
+		var buf bytes.Buffer
+		n, err := rheos.WriteLines(rheos.FromSlice(context.Background(), []string{"a", "", "bc"}), &buf)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := buf.String(); got != "a\n\nbc\n" {
+			t.Errorf("written %q, want %q", got, "a\n\nbc\n")
+		}
+		if n != 6 {
+			t.Errorf("written %d bytes, want 6", n)
+		}
+	})
+}
+
+func TestContentDefinedChunk(t *testing.T) {
+	const (
+		minSize = 64
+		maxSize = 1024
+		mask    = 1<<8 - 1
+	)
+	content := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	chunk := func(t *testing.T, data []byte, split int) [][]byte {
+		t.Helper()
+		var parts [][]byte
+		for len(data) > 0 {
+			n := split
+			if n > len(data) {
+				n = len(data)
+			}
+			parts = append(parts, data[:n])
+			data = data[n:]
+		}
+
+		got, err := rheos.Collect(rheos.ContentDefinedChunk(rheos.FromSlice(context.Background(), parts), minSize, maxSize, mask))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return got
+	}
+
+	t.Run("sizes and content", func(t *testing.T) {
+		chunks := chunk(t, content, 1000)
+		var joined []byte
+		for i, c := range chunks {
+			if len(c) > maxSize || (len(c) < minSize && i != len(chunks)-1) {
+				t.Errorf("chunk %d has size %d", i, len(c))
+			}
+			joined = append(joined, c...)
+		}
+		if !bytes.Equal(content, joined) {
+			t.Error("joined chunks differ from content")
+		}
+	})
+
+	t.Run("independent of input split", func(t *testing.T) {
+		a := chunk(t, content, 1000)
+		b := chunk(t, content, 7)
+		if len(a) != len(b) {
+			t.Fatalf("got %d and %d chunks", len(a), len(b))
+		}
+		for i := range a {
+			if !bytes.Equal(a[i], b[i]) {
+				t.Fatalf("chunk %d differs", i)
+			}
+		}
+	})
+
+	t.Run("insertion shifts only nearby chunks", func(t *testing.T) {
+		original := chunk(t, content, 1000)
+		modified := append(append(append([]byte{}, content[:100]...), []byte("inserted")...), content[100:]...)
+		shifted := chunk(t, modified, 1000)
+
+		seen := make(map[string]bool, len(original))
+		for _, c := range original {
+			seen[string(c)] = true
+		}
+		same := 0
+		for _, c := range shifted {
+			if seen[string(c)] {
+				same++
+			}
+		}
+		if same < len(original)-3 {
+			t.Errorf("only %d of %d chunks are the same after insertion", same, len(original))
+		}
+	})
+	t.Run("invalid sizes", func(t *testing.T) {
+		for _, sizes := range [][2]int{{-1, 10}, {0, 0}, {10, 5}} {
+			_, err := rheos.Collect(rheos.ContentDefinedChunk(rheos.FromSlice(context.Background(), [][]byte{content}), sizes[0], sizes[1], mask))
+			if !errors.Is(err, rheos.ErrInvalidChunkSize) {
+				t.Errorf("sizes %v: unexpected error: %v, want: %v", sizes, err, rheos.ErrInvalidChunkSize)
+			}
+		}
+	})
+}
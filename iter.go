@@ -13,13 +13,11 @@ import (
 // If seq returns error or context is cancelled during processing,
 // Stream stops processing and returns error.
 func FromSeq2[I any](ctx context.Context, seq iter.Seq2[I, error], ops ...Option[I]) Stream[I] {
-	results := make(chan I)
-	for _, op := range ops {
-		results = op()
-	}
+	results := newOutput(ctx, ops)
+	stopper := newStopper()
 
-	eg, ctx := errgroup.WithContext(ctx)
-	eg.Go(func() error {
+	eg, ctx := errgroup.WithContext(sourceContext(ctx, ops))
+	goStep(eg, ctx, "FromSeq2", applyOptions(ops), func() error {
 		defer close(results)
 
 		var err error
@@ -29,7 +27,7 @@ func FromSeq2[I any](ctx context.Context, seq iter.Seq2[I, error], ops ...Option
 				return false
 			}
 
-			err = push(ctx, results, elem)
+			err = emit(ctx, stopper, results, elem)
 			return err == nil
 		})
 
@@ -37,9 +35,10 @@ func FromSeq2[I any](ctx context.Context, seq iter.Seq2[I, error], ops ...Option
 	})
 
 	return Stream[I]{
-		in:  results,
-		eg:  eg,
-		ctx: ctx,
+		in:      results,
+		eg:      eg,
+		ctx:     ctx,
+		stopper: stopper,
 	}
 }
 
@@ -58,8 +57,14 @@ func FromSeq[I any](ctx context.Context, seq iter.Seq[I], ops ...Option[I]) Stre
 }
 
 // All returns an iterator over value-error pairs.
+// If context is cancelled during iteration, the received element is yielded together with the context error,
+// and the iteration stops. Such element may be incomplete, use AllStrict to not receive it at all.
+// When the iteration is stopped early, e.g. with break, the stream is stopped, so the steps of the pipeline end,
+// as with Take. Call Stream.Close instead of Stream.Wait afterwards, to wait for them.
 func All[I any](pipe Stream[I]) iter.Seq2[I, error] {
 	return func(yield func(I, error) bool) {
+		defer pipe.stop() // releases the steps, if the iteration stops early
+
 		for elem := range pipe.in {
 			if err := pipe.ctx.Err(); err != nil {
 				yield(elem, err)
@@ -72,3 +77,29 @@ func All[I any](pipe Stream[I]) iter.Seq2[I, error] {
 		}
 	}
 }
+
+// AllStrict returns an iterator over value-error pairs, like All, but an error is always yielded alone,
+// with the zero value, as the last pair of the iteration.
+// Unlike All, it also reports the pipeline error which occurred after the last element was yielded.
+// When the iteration is stopped early, the stream is stopped, like with All.
+func AllStrict[I any](pipe Stream[I]) iter.Seq2[I, error] {
+	return func(yield func(I, error) bool) {
+		defer pipe.stop() // releases the steps, if the iteration stops early
+
+		var zero I
+		for elem := range pipe.in {
+			if pipe.ctx.Err() != nil {
+				yield(zero, pipe.eg.Wait())
+				return
+			}
+
+			if !yield(elem, nil) {
+				return
+			}
+		}
+
+		if err := pipe.eg.Wait(); err != nil {
+			yield(zero, err)
+		}
+	}
+}
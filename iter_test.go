@@ -8,8 +8,10 @@ import (
 	"iter"
 	"maps"
 	"math/rand"
+	"runtime"
 	"slices"
 	"testing"
+	"time"
 
 	"github.com/dmksnnk/rheos"
 )
@@ -124,6 +126,109 @@ func TestAll(t *testing.T) {
 			t.Errorf("want %v, got %v", want, collected)
 		}
 	})
+
+	t.Run("stop early releases steps", func(t *testing.T) {
+		before := runtime.NumGoroutine()
+
+		var produced int64
+		mapped := rheos.Map(newInfiniteProducer(context.Background(), &produced), func(_ context.Context, v int) (int, error) {
+			return v * 2, nil
+		})
+		filtered := rheos.Filter(mapped, func(_ context.Context, v int) (bool, error) {
+			return v%4 == 0, nil
+		})
+		for v, err := range rheos.All(filtered) {
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if v >= 8 {
+				break
+			}
+		}
+
+		if err := filtered.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertNoLeak(t, before)
+	})
+}
+
+// assertNoLeak waits until the number of goroutines drops to before.
+func assertNoLeak(t *testing.T, before int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines are running, want at most %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAllStrict(t *testing.T) {
+	t.Run("no error", func(t *testing.T) {
+		num := rand.Intn(10) + 1
+		s := rheos.FromSeq2(context.TODO(), seq(num))
+
+		var got []int
+		for v, err := range rheos.AllStrict(s) {
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			got = append(got, v)
+		}
+		if !slices.Equal(intRange(num), got) {
+			t.Errorf("want %v, got %v", intRange(num), got)
+		}
+	})
+
+	t.Run("error yielded with zero value", func(t *testing.T) {
+		s := rheos.FromSlice(context.TODO(), []int{1, 2, 3, 4, 5})
+		mapped := rheos.Map(s, func(_ context.Context, v int) (int, error) {
+			if v == 3 {
+				return 0, errTest
+			}
+			return v, nil
+		})
+
+		var got []int
+		var gotErr error
+		for v, err := range rheos.AllStrict(mapped) {
+			if err != nil {
+				if v != 0 {
+					t.Errorf("want zero value with error, got %d", v)
+				}
+				gotErr = err
+				break
+			}
+			got = append(got, v)
+		}
+		if !errors.Is(gotErr, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", gotErr, errTest)
+		}
+		// elements before the failed one may be not received, because the pipeline is cancelled
+		if len(got) > 2 || !slices.Equal([]int{1, 2}[:len(got)], got) {
+			t.Errorf("want prefix of %v, got %v", []int{1, 2}, got)
+		}
+	})
+
+	t.Run("stop early releases steps", func(t *testing.T) {
+		before := runtime.NumGoroutine()
+
+		var produced int64
+		src := newInfiniteProducer(context.Background(), &produced)
+		for v, err := range rheos.AllStrict(rheos.Map(src, func(_ context.Context, v int) (int, error) { return v, nil })) {
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if v == 3 {
+				break
+			}
+		}
+
+		assertNoLeak(t, before)
+	})
 }
 
 func seq(n int) iter.Seq2[int, error] {
@@ -135,3 +240,35 @@ func seq(n int) iter.Seq2[int, error] {
 		}
 	}
 }
+
+func TestStreamWait(t *testing.T) {
+	t.Run("no error", func(t *testing.T) {
+		stream := rheos.FromSlice(context.Background(), []int{1, 2, 3})
+		var got []int
+		for v, err := range rheos.All(stream) {
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got = append(got, v)
+		}
+		if err := stream.Wait(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{1, 2, 3}, got)
+	})
+
+	t.Run("step error after last element", func(t *testing.T) {
+		stream := rheos.FromIter(context.Background(), func(yield func(int) bool) error {
+			yield(1)
+			return errTest
+		})
+		for range rheos.All(stream) {
+		}
+		if err := stream.Wait(); !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+		if err := stream.Context().Err(); !errors.Is(err, context.Canceled) {
+			t.Errorf("pipeline context error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
@@ -0,0 +1,177 @@
+package rheos
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DecodeError is returned when a record of the input can't be decoded.
+type DecodeError struct {
+	// Index is a zero-based position of the record in the input.
+	Index int
+	// Err is an underlying decoding error.
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decode record %d: %s", e.Index, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// FromGzipJSONLines creates a new Stream from gzip-compressed newline-delimited JSON (NDJSON).
+// It decompresses and decodes records one by one, without reading the whole input into memory.
+// If input is not a valid gzip, Stream stops processing and returns error.
+// If record can't be decoded, Stream stops processing and returns *DecodeError,
+// which terminals return wrapped in *PipelineError.
+// If context is cancelled during processing, Stream stops processing and returns error.
+func FromGzipJSONLines[I any](ctx context.Context, r io.Reader, ops ...Option[I]) Stream[I] {
+	return FromIter[I](ctx, func(yield func(I) bool) error {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("gzip: %w", err)
+		}
+		defer gz.Close()
+
+		return decodeJSONLines(json.NewDecoder(gz), yield)
+	}, ops...)
+}
+
+// FromJSON creates a new Stream from JSON input, which is either a top-level array of records
+// or newline-delimited JSON (NDJSON). It decodes records one by one, without reading the whole input into memory.
+// If record can't be decoded, Stream stops processing and returns *DecodeError,
+// which terminals return wrapped in *PipelineError.
+// If context is cancelled during processing, Stream stops processing and returns error,
+// though a read which is already blocked on r is not interrupted.
+func FromJSON[I any](ctx context.Context, r io.Reader, ops ...Option[I]) Stream[I] {
+	return FromIter[I](ctx, func(yield func(I) bool) error {
+		br := bufio.NewReader(r)
+		array, err := isJSONArray(br)
+		if err != nil {
+			return err
+		}
+
+		dec := json.NewDecoder(br)
+		if !array {
+			return decodeJSONLines(dec, yield)
+		}
+
+		if _, err := dec.Token(); err != nil { // opening bracket
+			return err
+		}
+
+		i := 0
+		for ; dec.More(); i++ {
+			var elem I
+			if err := dec.Decode(&elem); err != nil {
+				return &DecodeError{Index: i, Err: err}
+			}
+
+			if !yield(elem) {
+				return nil
+			}
+		}
+
+		if _, err := dec.Token(); err != nil { // closing bracket
+			return &DecodeError{Index: i, Err: err}
+		}
+
+		return nil
+	}, ops...)
+}
+
+// decodeJSONLines decodes consecutive records from dec until the end of the input.
+func decodeJSONLines[I any](dec *json.Decoder, yield func(I) bool) error {
+	for i := 0; ; i++ {
+		var elem I
+		if err := dec.Decode(&elem); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return &DecodeError{Index: i, Err: err}
+		}
+
+		if !yield(elem) {
+			return nil
+		}
+	}
+}
+
+// isJSONArray reports whether the input starts with an array, skipping leading whitespace.
+func isJSONArray(r *bufio.Reader) (bool, error) {
+	for {
+		b, err := r.ReadByte()
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+
+		return b == '[', r.UnreadByte()
+	}
+}
+
+// EncodeJSON writes each element of the stream to w encoded as JSON. If newlineDelimited is true,
+// it writes newline-delimited JSON (NDJSON), one record per line. Otherwise it writes a single array of records,
+// which is closed when the stream ends, so an empty stream is written as an empty array.
+// If encoding or writing fails or context is cancelled during processing, EncodeJSON stops and returns error,
+// the array is left unclosed then.
+func EncodeJSON[I any](pipe Stream[I], w io.Writer, newlineDelimited bool) error {
+	if newlineDelimited {
+		enc := json.NewEncoder(w)
+
+		return ForEach(pipe, func(_ context.Context, elem I) error {
+			if err := enc.Encode(elem); err != nil {
+				return fmt.Errorf("encode: %w", err)
+			}
+
+			return nil
+		})
+	}
+
+	count := 0
+	err := ForEach(pipe, func(_ context.Context, elem I) error {
+		data, err := json.Marshal(elem)
+		if err != nil {
+			return fmt.Errorf("encode: %w", err)
+		}
+
+		sep := []byte{','}
+		if count == 0 {
+			sep = []byte{'['}
+		}
+		if _, err := w.Write(append(sep, data...)); err != nil {
+			return fmt.Errorf("write: %w", err)
+		}
+		count++
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	end := "]\n"
+	if count == 0 {
+		end = "[]\n"
+	}
+	if _, err := io.WriteString(w, end); err != nil {
+		return newPipelineError(pipe.ctx, fmt.Errorf("write: %w", err), count)
+	}
+
+	return nil
+}
@@ -0,0 +1,224 @@
+package rheos_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dmksnnk/rheos"
+)
+
+type record struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestFromGzipJSONLines(t *testing.T) {
+	t.Run("decodes records", func(t *testing.T) {
+		input := gzipped(t, "{\"id\":1,\"name\":\"a\"}\n{\"id\":2,\"name\":\"b\"}\n")
+
+		got, err := rheos.Collect(rheos.FromGzipJSONLines[record](context.Background(), input))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []record{{ID: 1, Name: "a"}, {ID: 2, Name: "b"}}, got)
+	})
+
+	t.Run("decode error", func(t *testing.T) {
+		input := gzipped(t, "{\"id\":1}\n{\"id\":\"two\"}\n{\"id\":3}\n")
+
+		_, err := rheos.Collect(rheos.FromGzipJSONLines[record](context.Background(), input))
+		var decErr *rheos.DecodeError
+		if !errors.As(err, &decErr) {
+			t.Fatalf("unexpected error: %v, want DecodeError", err)
+		}
+		if decErr.Index != 1 {
+			t.Errorf("unexpected index: %d, want: 1", decErr.Index)
+		}
+	})
+
+	t.Run("not gzip", func(t *testing.T) {
+		input := bytes.NewBufferString("{\"id\":1}\n{\"id\":2}\n")
+
+		_, err := rheos.Collect(rheos.FromGzipJSONLines[record](context.Background(), input))
+		if !errors.Is(err, gzip.ErrHeader) {
+			t.Errorf("unexpected error: %v, want: %v", err, gzip.ErrHeader)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		input := gzipped(t, "{\"id\":1}\n{\"id\":2}\n")
+
+		_, err := rheos.Collect(rheos.FromGzipJSONLines[record](ctx, input))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestFromJSON(t *testing.T) {
+	want := []record{{ID: 1, Name: "a"}, {ID: 2, Name: "b"}}
+
+	t.Run("decodes array", func(t *testing.T) {
+		input := strings.NewReader(" \n[{\"id\":1,\"name\":\"a\"},\n {\"id\":2,\"name\":\"b\"}]\n")
+
+		got, err := rheos.Collect(rheos.FromJSON[record](context.Background(), input))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, want, got)
+	})
+
+	t.Run("decodes lines", func(t *testing.T) {
+		input := strings.NewReader("{\"id\":1,\"name\":\"a\"}\n{\"id\":2,\"name\":\"b\"}\n")
+
+		got, err := rheos.Collect(rheos.FromJSON[record](context.Background(), input))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, want, got)
+	})
+
+	t.Run("empty input", func(t *testing.T) {
+		for _, input := range []string{"", " \n", "[]"} {
+			got, err := rheos.Collect(rheos.FromJSON[record](context.Background(), strings.NewReader(input)))
+			if err != nil {
+				t.Fatalf("unexpected error for %q: %v", input, err)
+			}
+			if len(got) != 0 {
+				t.Errorf("want empty result for %q, got %v", input, got)
+			}
+		}
+	})
+
+	t.Run("array of arrays", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.FromJSON[[]int](context.Background(), strings.NewReader("[[1,2],[3]]")))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != 2 || len(got[0]) != 2 || len(got[1]) != 1 {
+			t.Errorf("unexpected result: %v", got)
+		}
+	})
+
+	t.Run("decode error", func(t *testing.T) {
+		for _, input := range []string{
+			"[{\"id\":1},{\"id\":\"two\"},{\"id\":3}]",
+			"{\"id\":1}\n{\"id\":\"two\"}\n{\"id\":3}\n",
+		} {
+			_, err := rheos.Collect(rheos.FromJSON[record](context.Background(), strings.NewReader(input)))
+			var decErr *rheos.DecodeError
+			if !errors.As(err, &decErr) {
+				t.Fatalf("unexpected error for %q: %v, want DecodeError", input, err)
+			}
+			if decErr.Index != 1 {
+				t.Errorf("unexpected index for %q: %d, want: 1", input, decErr.Index)
+			}
+		}
+	})
+
+	t.Run("truncated array", func(t *testing.T) {
+		_, err := rheos.Collect(rheos.FromJSON[record](context.Background(), strings.NewReader("[{\"id\":1}")))
+		var decErr *rheos.DecodeError
+		if !errors.As(err, &decErr) {
+			t.Fatalf("unexpected error: %v, want DecodeError", err)
+		}
+		if decErr.Index != 1 {
+			t.Errorf("unexpected index: %d, want: 1", decErr.Index)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := rheos.Collect(rheos.FromJSON[record](ctx, strings.NewReader("[{\"id\":1},{\"id\":2}]")))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestEncodeJSON(t *testing.T) {
+	records := []record{{ID: 1, Name: "a"}, {ID: 2, Name: "b"}}
+
+	t.Run("lines", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := rheos.EncodeJSON(rheos.FromSlice(context.Background(), records), &buf, true); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := "{\"id\":1,\"name\":\"a\"}\n{\"id\":2,\"name\":\"b\"}\n"
+		if got := buf.String(); got != want {
+			t.Errorf("written %q, want %q", got, want)
+		}
+	})
+
+	t.Run("array", func(t *testing.T) {
+		for _, tc := range []struct {
+			name    string
+			records []record
+			want    string
+		}{
+			{name: "empty", records: nil, want: "[]\n"},
+			{name: "single", records: records[:1], want: "[{\"id\":1,\"name\":\"a\"}]\n"},
+			{name: "multiple", records: records, want: "[{\"id\":1,\"name\":\"a\"},{\"id\":2,\"name\":\"b\"}]\n"},
+		} {
+			var buf bytes.Buffer
+			if err := rheos.EncodeJSON(rheos.FromSlice(context.Background(), tc.records), &buf, false); err != nil {
+				t.Fatalf("%s: unexpected error: %v", tc.name, err)
+			}
+			if got := buf.String(); got != tc.want {
+				t.Errorf("%s: written %q, want %q", tc.name, got, tc.want)
+			}
+
+			got, err := rheos.Collect(rheos.FromJSON[record](context.Background(), &buf))
+			if err != nil {
+				t.Fatalf("%s: unexpected decode error: %v", tc.name, err)
+			}
+			if len(got) != len(tc.records) {
+				t.Errorf("%s: decoded %d records, want %d", tc.name, len(got), len(tc.records))
+			}
+		}
+	})
+
+	t.Run("encode error", func(t *testing.T) {
+		for _, newlineDelimited := range []bool{true, false} {
+			var buf bytes.Buffer
+			err := rheos.EncodeJSON(rheos.FromSlice(context.Background(), []any{1, func() {}}), &buf, newlineDelimited)
+			var typeErr *json.UnsupportedTypeError
+			if !errors.As(err, &typeErr) {
+				t.Errorf("unexpected error: %v, want UnsupportedTypeError", err)
+			}
+		}
+	})
+
+	t.Run("write error", func(t *testing.T) {
+		for _, newlineDelimited := range []bool{true, false} {
+			err := rheos.EncodeJSON(rheos.FromSlice(context.Background(), records), &failingWriteSeeker{failAt: 1}, newlineDelimited)
+			if !errors.Is(err, errTest) {
+				t.Errorf("unexpected error: %v, want: %v", err, errTest)
+			}
+		}
+	})
+}
+
+func gzipped(t *testing.T, data string) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(data)); err != nil {
+		t.Fatalf("write gzip: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close gzip: %v", err)
+	}
+
+	return &buf
+}
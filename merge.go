@@ -0,0 +1,707 @@
+package rheos
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// MergeSortedUnique merges sorted streams into a single sorted stream, collapsing equal elements into one.
+// Each stream must be sorted according to less. It holds only one element per stream in memory.
+// If any of the streams returns error or context is cancelled during processing,
+// MergeSortedUnique stops processing and returns error.
+func MergeSortedUnique[I any](streams []Stream[I], less func(I, I) bool, equal func(I, I) bool, ops ...Option[I]) Stream[I] {
+	eg, ctx := joinedGroup(streams)
+	output := newOutput(ctx, ops)
+	stopper := newStopper()
+
+	attachCtx, detach := context.WithCancel(ctx)
+	inputs := make([]<-chan I, len(streams))
+	for i, s := range streams {
+		inputs[i] = attach(attachCtx, eg, s)
+	}
+
+	goStep(eg, ctx, "MergeSortedUnique", applyOptions(ops), func() error {
+		defer close(output)
+		defer detach() // stops the streams, if stopped before they end
+
+		heads := make([]I, len(inputs))
+		has := make([]bool, len(inputs))
+		for i, input := range inputs {
+			var err error
+			if heads[i], has[i], err = pull(ctx, input); err != nil {
+				return err
+			}
+		}
+
+		var (
+			last    I
+			emitted bool
+		)
+		for {
+			next := -1
+			for i := range heads {
+				if has[i] && (next < 0 || less(heads[i], heads[next])) {
+					next = i
+				}
+			}
+			if next < 0 {
+				return nil
+			}
+
+			if elem := heads[next]; !emitted || !equal(last, elem) {
+				if err := emit(ctx, stopper, output, elem); err != nil {
+					return err
+				}
+				last, emitted = elem, true
+			}
+
+			var err error
+			if heads[next], has[next], err = pull(ctx, inputs[next]); err != nil {
+				return err
+			}
+		}
+	})
+
+	return Stream[I]{
+		in:      output,
+		eg:      eg,
+		ctx:     ctx,
+		stopper: stopper,
+	}
+}
+
+// ControlledMap is like Map, but also passes the latest value from the control stream to the mapper.
+// It allows to tune mapping at runtime, e.g. to change a threshold, by sending new values to the control stream.
+// Until the first control value arrives, initial is used. When the control stream ends, its last value is kept.
+// The control stream is stopped when the stream ends.
+// If mapper or control stream returns error, or context is cancelled during processing,
+// ControlledMap stops processing and returns error.
+func ControlledMap[I, O, C any](pipe Stream[I], control Stream[C], mapper func(context.Context, C, I) (O, error), initial C, ops ...Option[O]) Stream[O] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	controlCtx, stopControl := context.WithCancel(pipe.ctx)
+	controls := attach(controlCtx, pipe.eg, control)
+
+	goStep(pipe.eg, pipe.ctx, "ControlledMap", applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+		defer stopControl()
+
+		current := initial
+		for {
+			select {
+			case <-pipe.ctx.Done():
+				return pipe.ctx.Err()
+			case value, ok := <-controls:
+				if !ok {
+					controls = nil // keep the last value
+
+					continue
+				}
+				current = value
+			case elem, ok := <-pipe.in:
+				if !ok {
+					return nil
+				}
+
+				current = latest(controls, current)
+				mapped, err := mapper(pipe.ctx, current, elem)
+				if err != nil {
+					return err
+				}
+
+				if err := emit(pipe.ctx, stopper, output, mapped); err != nil {
+					return err
+				}
+			}
+		}
+	})
+
+	return Stream[O]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// Concat emits all elements of the first stream, then all elements of the second one and so on.
+// A stream is not read until all the streams before it end. Note that the steps of each stream
+// are already started when it is created, so each source may produce an element
+// (or fill the buffer of its output) ahead, before Concat reaches it.
+// If any of the streams returns error or context is cancelled during processing,
+// Concat stops processing and returns error, the remaining streams are stopped without being read.
+func Concat[I any](pipes ...Stream[I]) Stream[I] {
+	output := make(chan I)
+	stopper := newStopper()
+
+	eg, ctx := joinedGroup(pipes)
+	goStep(eg, ctx, "Concat", Options[I]{}, func() error {
+		defer close(output)
+
+		attachCtx, detach := context.WithCancel(ctx)
+		defer detach()
+
+		next := 0
+		defer func() {
+			for _, rest := range pipes[next:] {
+				rest.stop()
+			}
+		}()
+
+		for next < len(pipes) {
+			pipe := pipes[next]
+			input := attach(attachCtx, eg, pipe)
+			next++
+
+			for {
+				elem, ok, err := pull(ctx, input)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					break
+				}
+
+				if err := emit(ctx, stopper, output, elem); err != nil {
+					return err
+				}
+			}
+
+			if pipe.eg.Wait() != nil { // the stream failed, wait until its error reaches the group
+				<-ctx.Done()
+
+				return ctx.Err()
+			}
+		}
+
+		return nil
+	})
+
+	return Stream[I]{
+		in:      output,
+		eg:      eg,
+		ctx:     ctx,
+		stopper: stopper,
+	}
+}
+
+// Merge emits elements of all the streams as they arrive, reading the streams concurrently.
+// The order of the elements is undefined, except that elements of each stream keep their order.
+// The stream ends when all the streams end.
+// If any of the streams returns error or context is cancelled during processing,
+// Merge stops processing and returns error, the remaining streams are stopped.
+func Merge[I any](pipes ...Stream[I]) Stream[I] {
+	return merge("Merge", len(pipes), pipes)
+}
+
+// MergeLimit is like Merge, but reads at most concurrency streams at a time, which bounds the goroutines
+// reading the streams for a large number of them. A stream is not read until one of the streams being read ends,
+// in order of the arguments. Note that the steps of each stream are already started when it is created,
+// so each source may produce an element (or fill the buffer of its output) ahead, before MergeLimit reaches it.
+// Concurrency less than 1 is treated as 1.
+// If any of the streams returns error or context is cancelled during processing,
+// MergeLimit stops processing and returns error, the remaining streams are stopped without being read.
+func MergeLimit[I any](concurrency int, pipes ...Stream[I]) Stream[I] {
+	return merge("MergeLimit", concurrency, pipes)
+}
+
+func merge[I any](name string, concurrency int, pipes []Stream[I]) Stream[I] {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	output := make(chan I)
+	stopper := newStopper()
+
+	eg, ctx := joinedGroup(pipes)
+	goStep(eg, ctx, name, Options[I]{}, func() error {
+		defer close(output)
+
+		attachCtx, detach := context.WithCancel(ctx)
+		defer detach() // stops the streams, if stopped before they end
+
+		next := 0
+		defer func() {
+			for _, rest := range pipes[next:] {
+				rest.stop()
+			}
+		}()
+
+		readers, readCtx := errgroup.WithContext(ctx)
+		slots := make(chan struct{}, concurrency)
+		for next < len(pipes) {
+			select {
+			case <-readCtx.Done():
+				return readers.Wait()
+			case slots <- struct{}{}:
+			}
+
+			input := attach(attachCtx, eg, pipes[next])
+			next++
+
+			readers.Go(func() error {
+				defer func() { <-slots }()
+
+				for {
+					elem, ok, err := pull(readCtx, input)
+					if err != nil || !ok {
+						return err
+					}
+
+					if err := emit(readCtx, stopper, output, elem); err != nil {
+						return err
+					}
+				}
+			})
+		}
+
+		return readers.Wait()
+	})
+
+	return Stream[I]{
+		in:      output,
+		eg:      eg,
+		ctx:     ctx,
+		stopper: stopper,
+	}
+}
+
+// Interleave emits elements of the streams in turns: one element of the first stream, one of the second one and so on,
+// then the first stream again. Ended streams are skipped, so the stream ends when all the streams end.
+// The order is deterministic: a slow stream delays the others until it produces its element or ends.
+// If any of the streams returns error or context is cancelled during processing,
+// Interleave stops processing and returns error, the remaining streams are stopped.
+func Interleave[I any](pipes ...Stream[I]) Stream[I] {
+	output := make(chan I)
+	stopper := newStopper()
+
+	eg, ctx := joinedGroup(pipes)
+	attachCtx, detach := context.WithCancel(ctx)
+	inputs := make([]<-chan I, len(pipes))
+	for i, pipe := range pipes {
+		inputs[i] = attach(attachCtx, eg, pipe)
+	}
+
+	goStep(eg, ctx, "Interleave", Options[I]{}, func() error {
+		defer close(output)
+		defer detach() // stops the streams, if stopped before they end
+
+		for active := len(inputs); active > 0; {
+			for i, input := range inputs {
+				if input == nil {
+					continue
+				}
+
+				elem, ok, err := pull(ctx, input)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					inputs[i] = nil
+					active--
+
+					continue
+				}
+
+				if err := emit(ctx, stopper, output, elem); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return Stream[I]{
+		in:      output,
+		eg:      eg,
+		ctx:     ctx,
+		stopper: stopper,
+	}
+}
+
+// Pair holds elements of two streams combined by Zip.
+type Pair[A any, B any] struct {
+	First  A
+	Second B
+}
+
+// Zip combines elements of two streams by their positions: the n-th element of a with the n-th element of b.
+// The stream ends when either of the streams ends, the remaining elements of the other stream are dropped
+// and it is stopped.
+// If any of the streams returns error or context is cancelled during processing, Zip stops processing and returns error.
+func Zip[A any, B any](a Stream[A], b Stream[B], ops ...Option[Pair[A, B]]) Stream[Pair[A, B]] {
+	output := newOutput(a.ctx, ops)
+	stopper := newStopper()
+
+	eg, ctx := errgroup.WithContext(detachedContext{Context: a.ctx})
+	attachCtx, detach := context.WithCancel(ctx)
+	firsts := attach(attachCtx, eg, a)
+	seconds := attach(attachCtx, eg, b)
+
+	goStep(eg, ctx, "Zip", applyOptions(ops), func() error {
+		defer close(output)
+		defer detach() // stops the streams, if one of them is longer
+
+		for {
+			first, ok, err := pull(ctx, firsts)
+			if err != nil || !ok {
+				return err
+			}
+			second, ok, err := pull(ctx, seconds)
+			if err != nil || !ok {
+				return err
+			}
+
+			if err := emit(ctx, stopper, output, Pair[A, B]{First: first, Second: second}); err != nil {
+				return err
+			}
+		}
+	})
+
+	return Stream[Pair[A, B]]{
+		in:      output,
+		eg:      eg,
+		ctx:     ctx,
+		stopper: stopper,
+	}
+}
+
+// Tee duplicates each element of the stream into both returned streams.
+// Elements are sent to the first stream, then to the second one, so both streams must be consumed concurrently:
+// a slow consumer of one stream slows down the other one, unless the streams are buffered with options.
+// When one of the streams is stopped, the other one still receives all elements.
+// Both streams share the pipeline of the input: if any of their steps fails, the whole pipeline is stopped.
+// If context is cancelled during processing, Tee stops processing and returns error.
+func Tee[I any](pipe Stream[I], ops ...Option[I]) (Stream[I], Stream[I]) {
+	outputs := broadcast(pipe, "Tee", 2, ops)
+
+	return outputs[0], outputs[1]
+}
+
+// Broadcast duplicates each element of the stream into n returned streams, n less than 1 is treated as 1.
+// The returned streams advance in lockstep: each element is sent to all of them, one by one,
+// before the next element is read, so an element waits until every stream receives it.
+// Therefore all the streams must be consumed concurrently, e.g. each in its own goroutine:
+// a stream which is not read blocks the others, and consuming the streams one after another deadlocks.
+// The slowest consumer sets the pace of all of them; buffers set with options let faster consumers
+// run ahead by the buffer size, before they wait for the slowest one.
+// When some of the streams are stopped, the others still receive all elements,
+// the input is stopped when all of the streams are stopped.
+// All the streams share the pipeline of the input: if any of their steps fails, the whole pipeline is stopped.
+// If context is cancelled during processing, Broadcast stops processing and returns error.
+func Broadcast[I any](pipe Stream[I], n int, ops ...Option[I]) []Stream[I] {
+	if n < 1 {
+		n = 1
+	}
+
+	return broadcast(pipe, "Broadcast", n, ops)
+}
+
+func broadcast[I any](pipe Stream[I], name string, n int, ops []Option[I]) []Stream[I] {
+	outputs := make([]chan I, n)
+	stoppers := make([]*stopper, n)
+	for i := range outputs {
+		outputs[i] = newOutput(pipe.ctx, ops)
+		stoppers[i] = newStopper()
+	}
+
+	goStep(pipe.eg, pipe.ctx, name, applyOptions(ops), func() error {
+		defer closeAll(outputs)
+		defer pipe.stop()
+
+		for elem := range pipe.in {
+			active, err := fanOut(pipe.ctx, stoppers, outputs, elem)
+			if err != nil || active == 0 {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	streams := make([]Stream[I], n)
+	for i := range streams {
+		streams[i] = Stream[I]{
+			in:      outputs[i],
+			eg:      pipe.eg,
+			ctx:     pipe.ctx,
+			stopper: stoppers[i],
+		}
+	}
+
+	return streams
+}
+
+// Partition splits the stream into two: elements for which pred returns true go to the first stream,
+// the others go to the second one. Both streams share the pipeline of the input,
+// so both must be consumed concurrently: while one stream is not read, the other one does not receive elements,
+// unless the streams are buffered with options. To discard one of the streams, stop it with Take(pipe, 0),
+// then its elements are dropped.
+// If pred returns error or context is cancelled during processing, Partition stops processing and returns error.
+func Partition[I any](pipe Stream[I], pred func(context.Context, I) (bool, error), ops ...Option[I]) (Stream[I], Stream[I]) {
+	outputs := []chan I{newOutput(pipe.ctx, ops), newOutput(pipe.ctx, ops)}
+	stoppers := []*stopper{newStopper(), newStopper()}
+
+	goStep(pipe.eg, pipe.ctx, "Partition", applyOptions(ops), func() error {
+		defer closeAll(outputs)
+		defer pipe.stop()
+
+		stopped := make([]bool, len(outputs))
+		for elem := range pipe.in {
+			ok, err := pred(pipe.ctx, elem)
+			if err != nil {
+				return err
+			}
+			i := 1
+			if ok {
+				i = 0
+			}
+			if stopped[i] {
+				continue
+			}
+
+			err = emit(pipe.ctx, stoppers[i], outputs[i], elem)
+			if errors.Is(err, errStopped) {
+				stopped[i] = true
+				if stopped[0] && stopped[1] {
+					return nil
+				}
+
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return Stream[I]{
+		in:      outputs[0],
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stoppers[0],
+	}, Stream[I]{
+		in:      outputs[1],
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stoppers[1],
+	}
+}
+
+// Shard is a stream of elements with the same key, emitted by SplitByKey.
+type Shard[K comparable, I any] struct {
+	// Key is the key of the elements of the stream.
+	Key K
+	// Stream receives the elements with the key in order of arrival.
+	Stream Stream[I]
+}
+
+// SplitByKey routes elements of the stream into a separate stream per key. The first time a key is seen,
+// a Shard with a new stream for the key is emitted, before any element is sent to that stream, so consumers
+// learn about all the streams from the returned stream, without missing elements.
+// The streams of the shards share the pipeline of the input, so each of them must be consumed concurrently
+// with the returned stream and each other, e.g. in its own goroutine: while an element is not received
+// by the stream of its key, no other elements are routed, unless the streams are buffered with options.
+// Stopped streams, e.g. with Take, drop the elements of their keys. When the returned stream is stopped,
+// no new shards are emitted and elements with new keys are dropped. The input is stopped
+// when the returned stream and the streams of all the shards are stopped.
+// If key returns error or context is cancelled during processing, SplitByKey stops processing and returns error.
+func SplitByKey[I any, K comparable](pipe Stream[I], key func(context.Context, I) (K, error), ops ...Option[I]) Stream[Shard[K, I]] {
+	opts := applyOptions(ops)
+	output := make(chan Shard[K, I])
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, "SplitByKey", opts, func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		routes := make(map[K]*route[I])
+		var outputs []chan I
+		defer func() { closeAll(outputs) }()
+
+		active := 0 // number of shards which are not stopped
+		announcing := true
+		for elem := range pipe.in {
+			k, err := key(pipe.ctx, elem)
+			if err != nil {
+				return err
+			}
+
+			r, ok := routes[k]
+			if !ok {
+				r = &route[I]{output: make(chan I, opts.buffer(pipe.ctx)), stopper: newStopper()}
+				routes[k] = r
+				outputs = append(outputs, r.output)
+				active++
+
+				shard := Shard[K, I]{Key: k, Stream: Stream[I]{
+					in:      r.output,
+					eg:      pipe.eg,
+					ctx:     pipe.ctx,
+					stopper: r.stopper,
+				}}
+				err := errStopped
+				if announcing {
+					err = emit(pipe.ctx, stopper, output, shard)
+				}
+				if errors.Is(err, errStopped) {
+					announcing = false
+					r.stopper.stop() // nobody receives the shard
+				} else if err != nil {
+					return err
+				}
+			}
+			if r.stopped {
+				continue
+			}
+
+			err = emit(pipe.ctx, r.stopper, r.output, elem)
+			if errors.Is(err, errStopped) {
+				r.stopped = true
+				active--
+				if active == 0 && !announcing {
+					return nil
+				}
+
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return Stream[Shard[K, I]]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// route is the output of a Shard stream.
+type route[I any] struct {
+	output  chan I
+	stopper *stopper
+	stopped bool
+}
+
+// fanOut emits elem to each of the outputs which is not stopped yet, one by one.
+// It returns the number of outputs which are not stopped.
+func fanOut[I any](ctx context.Context, stoppers []*stopper, outputs []chan I, elem I) (int, error) {
+	active := 0
+	for i, output := range outputs {
+		err := emit(ctx, stoppers[i], output, elem)
+		if errors.Is(err, errStopped) {
+			continue
+		}
+		if err != nil {
+			return active, err
+		}
+		active++
+	}
+
+	return active, nil
+}
+
+func closeAll[I any](outputs []chan I) {
+	for _, output := range outputs {
+		close(output)
+	}
+}
+
+// latest returns the last value available in ch without blocking, or current if there are none.
+func latest[C any](ch <-chan C, current C) C {
+	for {
+		select {
+		case value, ok := <-ch:
+			if !ok {
+				return current
+			}
+			current = value
+		default:
+			return current
+		}
+	}
+}
+
+// joinedGroup creates a new errgroup for a step combining multiple streams.
+// Its context carries the values of the first stream context, but not its cancellation:
+// streams are joined with attach, which propagates their errors into the group.
+func joinedGroup[I any](streams []Stream[I]) (*errgroup.Group, context.Context) {
+	parent := context.Background()
+	if len(streams) > 0 {
+		parent = detachedContext{Context: streams[0].ctx}
+	}
+
+	return errgroup.WithContext(parent)
+}
+
+// errDetached stops a pipe attached to another pipeline.
+var errDetached = errors.New("detached")
+
+// attach joins pipe to a pipeline of another errgroup and context, returning a channel with pipe elements.
+// Errors of pipe are propagated into eg. When ctx is done, the pipe is stopped and its error is ignored,
+// as ctx is done either because the joined pipeline already failed or because the pipe is no longer needed.
+func attach[I any](ctx context.Context, eg *errgroup.Group, pipe Stream[I]) <-chan I {
+	output := relay(ctx, pipe)
+	eg.Go(func() error {
+		if err := pipe.eg.Wait(); !errors.Is(err, errDetached) {
+			return err
+		}
+
+		return nil
+	})
+
+	return output
+}
+
+// relay returns a channel with pipe elements, which stops the pipe with errDetached when ctx is done.
+func relay[I any](ctx context.Context, pipe Stream[I]) <-chan I {
+	output := make(chan I)
+
+	pipe.eg.Go(func() error {
+		defer close(output)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return errDetached // fails the pipe, so it stops
+			case elem, ok := <-pipe.in:
+				if !ok {
+					return pipe.ctx.Err()
+				}
+
+				select {
+				case <-ctx.Done():
+					return errDetached
+				case <-pipe.ctx.Done():
+					return pipe.ctx.Err()
+				case output <- elem:
+				}
+			}
+		}
+	})
+
+	return output
+}
+
+// detachedContext carries values of the parent context, but is never cancelled.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+func (detachedContext) Done() <-chan struct{} { return nil }
+
+func (detachedContext) Err() error { return nil }
@@ -0,0 +1,731 @@
+package rheos_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dmksnnk/rheos"
+)
+
+func lessInt(a, b int) bool {
+	return a < b
+}
+
+func equalInt(a, b int) bool {
+	return a == b
+}
+
+func TestMergeSortedUnique(t *testing.T) {
+	t.Run("merges without duplicates", func(t *testing.T) {
+		streams := []rheos.Stream[int]{
+			rheos.FromSlice(context.Background(), []int{1, 3, 5, 7}),
+			rheos.FromSlice(context.Background(), []int{1, 2, 3, 3, 8}),
+			rheos.FromSlice(context.Background(), []int{}),
+			rheos.FromSlice(context.Background(), []int{0, 7, 9}),
+		}
+
+		got, err := rheos.Collect(rheos.MergeSortedUnique(streams, lessInt, equalInt))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{0, 1, 2, 3, 5, 7, 8, 9}, got)
+	})
+
+	t.Run("no streams", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.MergeSortedUnique(nil, lessInt, equalInt))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{}, got)
+	})
+
+	t.Run("input error", func(t *testing.T) {
+		failing := rheos.Map(newProducer(context.Background(), 10), func(_ context.Context, v int) (int, error) {
+			if v == 5 {
+				return 0, errTest
+			}
+			return v, nil
+		})
+		streams := []rheos.Stream[int]{
+			newProducer(context.Background(), 100),
+			failing,
+		}
+
+		_, err := rheos.Collect(rheos.MergeSortedUnique(streams, lessInt, equalInt))
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+
+	t.Run("output error stops inputs", func(t *testing.T) {
+		streams := []rheos.Stream[int]{
+			newProducer(context.Background(), 100),
+			newProducer(context.Background(), 100),
+		}
+		merged := rheos.MergeSortedUnique(streams, lessInt, equalInt)
+
+		err := rheos.ForEach(merged, func(_ context.Context, v int) error {
+			if v == 10 {
+				return errTest
+			}
+			return nil
+		})
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+
+	t.Run("stopped by Take", func(t *testing.T) {
+		var produced int64
+		streams := []rheos.Stream[int]{
+			newInfiniteProducer(context.Background(), &produced),
+			newInfiniteProducer(context.Background(), &produced),
+		}
+
+		got, err := rheos.Collect(rheos.Take(rheos.MergeSortedUnique(streams, lessInt, equalInt), 5))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(5), got)
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		streams := []rheos.Stream[int]{
+			newProducer(context.Background(), 10),
+			newProducer(ctx, 10),
+		}
+
+		_, err := rheos.Collect(rheos.MergeSortedUnique(streams, lessInt, equalInt))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestConcat(t *testing.T) {
+	t.Run("concatenates in order", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.Concat(
+			rheos.FromSlice(context.Background(), []int{1, 2}),
+			rheos.FromSlice(context.Background(), []int{}),
+			rheos.FromSlice(context.Background(), []int{3, 4, 5}),
+		))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{1, 2, 3, 4, 5}, got)
+	})
+
+	t.Run("no streams", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.Concat[int]())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != 0 {
+			t.Errorf("want empty result, got %v", got)
+		}
+	})
+
+	t.Run("later streams are not read ahead", func(t *testing.T) {
+		var produced int64
+		first := rheos.FromSlice(context.Background(), []int{1, 2, 3})
+		second := newInfiniteProducer(context.Background(), &produced)
+
+		got, err := rheos.Collect(rheos.Take(rheos.Concat(first, second), 2))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{1, 2}, got)
+		if p := atomic.LoadInt64(&produced); p != 0 {
+			t.Errorf("second stream produced %d elements, want none", p)
+		}
+	})
+
+	t.Run("error stops remaining streams", func(t *testing.T) {
+		failing := rheos.FromIter(context.Background(), func(yield func(int) bool) error {
+			yield(1)
+			return errTest
+		})
+		var produced int64
+		_, err := rheos.Collect(rheos.Concat(failing, newInfiniteProducer(context.Background(), &produced)))
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+		if p := atomic.LoadInt64(&produced); p != 0 {
+			t.Errorf("second stream produced %d elements, want none", p)
+		}
+	})
+}
+
+func TestMerge(t *testing.T) {
+	t.Run("merges all elements", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.Merge(
+			rheos.FromSlice(context.Background(), []int{0, 1, 2}),
+			rheos.FromSlice(context.Background(), []int{}),
+			rheos.FromSlice(context.Background(), []int{3, 4}),
+			newProducer(context.Background(), 10),
+		))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		sort.Ints(got)
+		assertSlicesEqual(t, []int{0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 6, 7, 8, 9}, got)
+	})
+
+	t.Run("no streams", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.Merge[int]())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != 0 {
+			t.Errorf("want empty result, got %v", got)
+		}
+	})
+
+	t.Run("stopped by Take", func(t *testing.T) {
+		var produced int64
+		got, err := rheos.Collect(rheos.Take(rheos.Merge(
+			newInfiniteProducer(context.Background(), &produced),
+			newInfiniteProducer(context.Background(), &produced),
+		), 10))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != 10 {
+			t.Errorf("got %d elements, want 10", len(got))
+		}
+	})
+
+	t.Run("input error", func(t *testing.T) {
+		failing := rheos.FromIter(context.Background(), func(yield func(int) bool) error {
+			yield(1)
+			return errTest
+		})
+		var produced int64
+		_, err := rheos.Collect(rheos.Merge(newInfiniteProducer(context.Background(), &produced), failing))
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := rheos.Collect(rheos.Merge(
+			newProducer(context.Background(), 10),
+			newProducer(ctx, 10),
+		))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestMergeLimit(t *testing.T) {
+	t.Run("one at a time keeps order", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.MergeLimit(1,
+			rheos.FromSlice(context.Background(), []int{1, 2}),
+			rheos.FromSlice(context.Background(), []int{}),
+			rheos.FromSlice(context.Background(), []int{3, 4, 5}),
+		))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{1, 2, 3, 4, 5}, got)
+	})
+
+	t.Run("next stream is read when one ends", func(t *testing.T) {
+		release := make(chan struct{})
+		blocked := func(v int) rheos.Stream[int] {
+			return rheos.FromIter(context.Background(), func(yield func(int) bool) error {
+				yield(v)
+				<-release
+				return nil
+			})
+		}
+
+		received := 0
+		err := rheos.ForEach(rheos.MergeLimit(2, blocked(1), blocked(2), rheos.FromSlice(context.Background(), []int{100})), func(_ context.Context, v int) error {
+			received++
+			if v == 100 && received <= 2 {
+				t.Error("third stream is read while two streams are being read")
+			}
+			if received == 2 {
+				close(release)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if received != 3 {
+			t.Errorf("received %d elements, want 3", received)
+		}
+	})
+
+	t.Run("error stops remaining streams", func(t *testing.T) {
+		failing := rheos.FromIter(context.Background(), func(yield func(int) bool) error {
+			yield(1)
+			return errTest
+		})
+		var produced int64
+		_, err := rheos.Collect(rheos.MergeLimit(1, failing, newInfiniteProducer(context.Background(), &produced)))
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+
+	t.Run("stopped by Take", func(t *testing.T) {
+		var produced int64
+		streams := make([]rheos.Stream[int], 5)
+		for i := range streams {
+			streams[i] = newInfiniteProducer(context.Background(), &produced)
+		}
+
+		got, err := rheos.Collect(rheos.Take(rheos.MergeLimit(2, streams...), 10))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != 10 {
+			t.Errorf("got %d elements, want 10", len(got))
+		}
+	})
+}
+
+func TestInterleave(t *testing.T) {
+	t.Run("takes elements in turns", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.Interleave(
+			rheos.FromSlice(context.Background(), []int{1, 4, 7, 9}),
+			rheos.FromSlice(context.Background(), []int{}),
+			rheos.FromSlice(context.Background(), []int{2, 5}),
+			rheos.FromSlice(context.Background(), []int{3, 6, 8}),
+		))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{1, 2, 3, 4, 5, 6, 7, 8, 9}, got)
+	})
+
+	t.Run("no streams", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.Interleave[int]())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != 0 {
+			t.Errorf("want empty result, got %v", got)
+		}
+	})
+
+	t.Run("stopped by Take", func(t *testing.T) {
+		var produced int64
+		got, err := rheos.Collect(rheos.Take(rheos.Interleave(
+			newInfiniteProducer(context.Background(), &produced),
+			rheos.FromSlice(context.Background(), []int{-1, -2}),
+		), 6))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{0, -1, 1, -2, 2, 3}, got)
+	})
+
+	t.Run("input error", func(t *testing.T) {
+		failing := rheos.FromIter(context.Background(), func(yield func(int) bool) error {
+			yield(1)
+			return errTest
+		})
+		var produced int64
+		_, err := rheos.Collect(rheos.Interleave(newInfiniteProducer(context.Background(), &produced), failing))
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := rheos.Collect(rheos.Interleave(
+			newProducer(context.Background(), 10),
+			newProducer(ctx, 10),
+		))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestZip(t *testing.T) {
+	t.Run("pairs elements", func(t *testing.T) {
+		letters := rheos.FromSlice(context.Background(), []string{"a", "b", "c"})
+		var produced int64
+		zipped := rheos.Zip(newInfiniteProducer(context.Background(), &produced), letters)
+
+		got, err := rheos.Collect(zipped)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := []rheos.Pair[int, string]{{0, "a"}, {1, "b"}, {2, "c"}}
+		assertSlicesEqual(t, want, got)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		failing := rheos.Map(newProducer(context.Background(), 10), func(_ context.Context, v int) (int, error) {
+			if v == 3 {
+				return 0, errTest
+			}
+			return v, nil
+		})
+		_, err := rheos.Collect(rheos.Zip(newProducer(context.Background(), 10), failing))
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := rheos.Collect(rheos.Zip(newProducer(context.Background(), 10), newProducer(ctx, 10)))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestTee(t *testing.T) {
+	t.Run("duplicates elements", func(t *testing.T) {
+		num := 10
+		first, second := rheos.Tee(newProducer(context.Background(), num))
+
+		sum := make(chan int)
+		go func() {
+			total, _ := rheos.Reduce(second, func(acc, v int) (int, error) { return acc + v, nil }, 0)
+			sum <- total
+		}()
+		got, err := rheos.Collect(first)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(num), got)
+		if total := <-sum; total != 45 {
+			t.Errorf("got sum %d, want 45", total)
+		}
+	})
+
+	t.Run("stopped branch", func(t *testing.T) {
+		num := 10
+		first, second := rheos.Tee(newProducer(context.Background(), num))
+
+		taken := make(chan []int)
+		go func() {
+			got, _ := rheos.Collect(rheos.Take(second, 2))
+			taken <- got
+		}()
+		got, err := rheos.Collect(first)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(num), got)
+		assertSlicesEqual(t, []int{0, 1}, <-taken)
+	})
+
+	t.Run("branch error stops pipeline", func(t *testing.T) {
+		var produced int64
+		first, second := rheos.Tee(newInfiniteProducer(context.Background(), &produced))
+
+		go func() {
+			_ = rheos.ForEach(second, func(context.Context, int) error { return nil })
+		}()
+		err := rheos.ForEach(first, func(_ context.Context, v int) error {
+			if v == 5 {
+				return errTest
+			}
+			return nil
+		})
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+}
+
+func TestBroadcast(t *testing.T) {
+	t.Run("each stream receives all elements", func(t *testing.T) {
+		num := 10
+		streams := rheos.Broadcast(newProducer(context.Background(), num), 3)
+		if len(streams) != 3 {
+			t.Fatalf("got %d streams, want 3", len(streams))
+		}
+
+		results := make([]chan []int, len(streams))
+		for i, s := range streams {
+			results[i] = make(chan []int, 1)
+			go func(s rheos.Stream[int], result chan<- []int) {
+				got, _ := rheos.Collect(s)
+				result <- got
+			}(s, results[i])
+		}
+		for _, result := range results {
+			assertSlicesEqual(t, intRange(num), <-result)
+		}
+	})
+
+	t.Run("n less than 1", func(t *testing.T) {
+		streams := rheos.Broadcast(newProducer(context.Background(), 5), 0)
+		if len(streams) != 1 {
+			t.Fatalf("got %d streams, want 1", len(streams))
+		}
+		got, err := rheos.Collect(streams[0])
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(5), got)
+	})
+
+	t.Run("stopped streams", func(t *testing.T) {
+		var produced int64
+		streams := rheos.Broadcast(newInfiniteProducer(context.Background(), &produced), 3)
+
+		taken := make(chan []int, 2)
+		for _, s := range streams[1:] {
+			go func(s rheos.Stream[int]) {
+				got, _ := rheos.Collect(rheos.Take(s, 2))
+				taken <- got
+			}(s)
+		}
+		got, err := rheos.Collect(rheos.Take(streams[0], 20))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(20), got)
+		assertSlicesEqual(t, []int{0, 1}, <-taken)
+		assertSlicesEqual(t, []int{0, 1}, <-taken)
+	})
+
+	t.Run("stream error stops pipeline", func(t *testing.T) {
+		var produced int64
+		streams := rheos.Broadcast(newInfiniteProducer(context.Background(), &produced), 2, rheos.WithBuffer[int](4))
+
+		go func() {
+			_ = rheos.Drain(streams[1])
+		}()
+		err := rheos.ForEach(streams[0], func(_ context.Context, v int) error {
+			if v == 5 {
+				return errTest
+			}
+			return nil
+		})
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+}
+
+func TestPartition(t *testing.T) {
+	even := func(_ context.Context, v int) (bool, error) { return v%2 == 0, nil }
+
+	t.Run("splits by predicate", func(t *testing.T) {
+		evens, odds := rheos.Partition(newProducer(context.Background(), 10), even)
+
+		gotOdds := make(chan []int)
+		go func() {
+			got, _ := rheos.Collect(odds)
+			gotOdds <- got
+		}()
+		got, err := rheos.Collect(evens)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{0, 2, 4, 6, 8}, got)
+		assertSlicesEqual(t, []int{1, 3, 5, 7, 9}, <-gotOdds)
+	})
+
+	t.Run("discarded stream", func(t *testing.T) {
+		evens, odds := rheos.Partition(newProducer(context.Background(), 10), even)
+		rheos.Take(odds, 0)
+
+		got, err := rheos.Collect(evens)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{0, 2, 4, 6, 8}, got)
+	})
+
+	t.Run("predicate error", func(t *testing.T) {
+		evens, odds := rheos.Partition(newProducer(context.Background(), 10), func(_ context.Context, v int) (bool, error) {
+			if v == 5 {
+				return false, errTest
+			}
+			return v%2 == 0, nil
+		})
+		rheos.Take(odds, 0)
+
+		_, err := rheos.Collect(evens)
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+}
+
+func TestSplitByKey(t *testing.T) {
+	mod3 := func(_ context.Context, v int) (int, error) { return v % 3, nil }
+
+	// consume collects each shard stream concurrently, applying fn to it.
+	consume := func(t *testing.T, shards rheos.Stream[rheos.Shard[int, int]], fn func(rheos.Stream[int]) rheos.Stream[int]) map[int][]int {
+		t.Helper()
+
+		var (
+			mu  sync.Mutex
+			wg  sync.WaitGroup
+			got = make(map[int][]int)
+		)
+		err := rheos.ForEach(shards, func(_ context.Context, shard rheos.Shard[int, int]) error {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				items, _ := rheos.Collect(fn(shard.Stream))
+				mu.Lock()
+				got[shard.Key] = items
+				mu.Unlock()
+			}()
+			return nil
+		})
+		wg.Wait()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		return got
+	}
+
+	t.Run("routes by key", func(t *testing.T) {
+		got := consume(t, rheos.SplitByKey(newProducer(context.Background(), 10), mod3), func(s rheos.Stream[int]) rheos.Stream[int] { return s })
+		if len(got) != 3 {
+			t.Fatalf("got %d shards, want 3", len(got))
+		}
+		assertSlicesEqual(t, []int{0, 3, 6, 9}, got[0])
+		assertSlicesEqual(t, []int{1, 4, 7}, got[1])
+		assertSlicesEqual(t, []int{2, 5, 8}, got[2])
+	})
+
+	t.Run("stopped shards", func(t *testing.T) {
+		var produced int64
+		input := rheos.Take(newInfiniteProducer(context.Background(), &produced), 30)
+		got := consume(t, rheos.SplitByKey(input, mod3), func(s rheos.Stream[int]) rheos.Stream[int] { return rheos.Take(s, 2) })
+		assertSlicesEqual(t, []int{0, 3}, got[0])
+		assertSlicesEqual(t, []int{1, 4}, got[1])
+		assertSlicesEqual(t, []int{2, 5}, got[2])
+	})
+
+	t.Run("all stopped stops input", func(t *testing.T) {
+		var produced int64
+		shards := rheos.Take(rheos.SplitByKey(newInfiniteProducer(context.Background(), &produced), mod3), 2)
+		got := consume(t, shards, func(s rheos.Stream[int]) rheos.Stream[int] { return rheos.Take(s, 3) })
+		if len(got) != 2 {
+			t.Fatalf("got %d shards, want 2", len(got))
+		}
+		assertSlicesEqual(t, []int{0, 3, 6}, got[0])
+		assertSlicesEqual(t, []int{1, 4, 7}, got[1])
+	})
+
+	t.Run("key error", func(t *testing.T) {
+		shards := rheos.SplitByKey(newProducer(context.Background(), 10), func(_ context.Context, v int) (int, error) {
+			if v == 5 {
+				return 0, errTest
+			}
+			return v % 2, nil
+		})
+
+		err := rheos.ForEach(shards, func(_ context.Context, shard rheos.Shard[int, int]) error {
+			go func() {
+				_ = rheos.Drain(shard.Stream)
+			}()
+			return nil
+		})
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+}
+
+func TestControlledMap(t *testing.T) {
+	multiply := func(_ context.Context, factor int, v int) (int, error) {
+		return factor * v, nil
+	}
+
+	t.Run("uses latest control", func(t *testing.T) {
+		input := make(chan int)
+		controlInput := make(chan int)
+		mapped := rheos.ControlledMap(
+			rheos.FromChannel(context.Background(), input),
+			rheos.FromChannel(context.Background(), controlInput),
+			multiply,
+			1,
+		)
+
+		results := make(chan int)
+		done := make(chan error)
+		go func() {
+			done <- rheos.ForEach(mapped, func(_ context.Context, v int) error {
+				results <- v
+				return nil
+			})
+		}()
+
+		input <- 1
+		if got := <-results; got != 1 {
+			t.Errorf("got %d, want 1", got)
+		}
+		controlInput <- 10
+		close(controlInput)
+		time.Sleep(10 * time.Millisecond) // let control value propagate
+		input <- 2
+		if got := <-results; got != 20 {
+			t.Errorf("got %d, want 20", got)
+		}
+		input <- 3 // last control value is kept
+		if got := <-results; got != 30 {
+			t.Errorf("got %d, want 30", got)
+		}
+		close(input)
+
+		if err := <-done; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("stops infinite control", func(t *testing.T) {
+		infinite := rheos.FromIter(context.Background(), func(yield func(int) bool) error {
+			for yield(1) {
+			}
+			return nil
+		})
+
+		got, err := rheos.Collect(rheos.ControlledMap(newProducer(context.Background(), 10), infinite, multiply, 1))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(10), got)
+	})
+
+	t.Run("control error", func(t *testing.T) {
+		failing := rheos.FromIter(context.Background(), func(yield func(int) bool) error {
+			return errTest
+		})
+		input := make(chan int) // never sends
+		mapped := rheos.ControlledMap(rheos.FromChannel(context.Background(), input), failing, multiply, 1)
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			close(input)
+		}()
+		_, err := rheos.Collect(mapped)
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+}
@@ -1,11 +1,334 @@
 package rheos
 
-// Option to configure the pipeline steps.
-type Option[T any] func() chan T
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
 
-// WithBuffer sets the stream buffer capacity.
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/semaphore"
+)
+
+// ErrUnsupportedOption is returned by a step, which is configured with an option of another step,
+// e.g. Map with WithWindow.
+var ErrUnsupportedOption = errors.New("option is not supported by the step")
+
+// Option to configure the pipeline steps with output elements of type T.
+// Custom options can be written as functions which modify Options.
+type Option[T any] func(*Options[T])
+
+// Options are the settings of a pipeline step with output elements of type T, configured with Option.
+// Exported fields are shared by all steps. Settings of specific steps, like the window of ParMapOrdered,
+// are set only with their options, e.g. WithWindow, and other steps reject them.
+type Options[T any] struct {
+	// Buffer is the capacity of the step output channel.
+	// Zero means the default of the pipeline, set with WithDefaultBuffer.
+	Buffer int
+	// bufferSet tells that Buffer is set with WithBuffer, so zero value overrides the default of the pipeline.
+	bufferSet bool
+	// specific are the settings of specific steps.
+	specific specificSettings
+	// Concurrency is the total number of elements processed concurrently by parallel steps of the pipeline.
+	// It is used only by sources, zero means no limit.
+	Concurrency int
+	// Observer receives the events of the step callback. Nil means no observer.
+	Observer Observer
+	// Tracer starts a span for each call of the step callback, named SpanName. Nil means no tracing.
+	Tracer   trace.Tracer
+	SpanName string
+	// ErrorHandler is called with the error of the step. Nil means no handler.
+	ErrorHandler func(error)
+	// HandleCancel makes ErrorHandler also receive errors caused by the context cancellation.
+	HandleCancel bool
+}
+
+// specificSettings are the settings used only by some steps.
+// Other steps fail with ErrUnsupportedOption, when they are configured with the options of the settings.
+type specificSettings struct {
+	// window is the maximum number of elements in flight of the order-preserving parallel steps,
+	// like ParMapOrdered. Zero means the default of the step.
+	window int
+	// maxLineSize is the maximum length of a line read by FromReader.
+	// Zero means the default of bufio.Scanner, bufio.MaxScanTokenSize.
+	maxLineSize int
+	// comma is the field separator of FromCSV. Zero means comma.
+	comma rune
+	// skipHeader makes FromCSV skip the first record.
+	skipHeader bool
+	// batchPool is the pool of slices for batches emitted by Batch, BatchTimeout and BatchWeighted.
+	// Nil means a new slice for each batch.
+	batchPool *sync.Pool
+
+	set      setting // settings set with options
+	accepted setting // settings used by the step
+}
+
+// setting is a flag of a specific setting.
+type setting uint8
+
+const (
+	windowSetting setting = 1 << iota
+	maxLineSizeSetting
+	commaSetting
+	skipHeaderSetting
+	batchPoolSetting
+)
+
+// settingOptions are the names of the options of settings, in the order of the flags.
+var settingOptions = []string{"WithWindow", "WithMaxLineSize", "WithComma", "WithSkipHeader", "WithBatchPool"}
+
+// unsupported returns ErrUnsupportedOption for the first setting, which is set, but not used by the step.
+func (s specificSettings) unsupported() error {
+	for i, name := range settingOptions {
+		if s.set&^s.accepted&(1<<i) != 0 {
+			return fmt.Errorf("%w: %s", ErrUnsupportedOption, name)
+		}
+	}
+
+	return nil
+}
+
+// Observer receives the events of the step callback, set with WithObserver.
+// Parallel steps call it concurrently, so it must be safe for concurrent use.
+type Observer interface {
+	// OnElement is called when the callback processes an element without error.
+	OnElement()
+	// OnError is called when the callback returns error.
+	OnError(err error)
+	// OnDuration is called with the duration of each callback call.
+	OnDuration(d time.Duration)
+}
+
+// DropObserver is an Observer, which is also notified by steps dropping elements under load,
+// like BufferDropOldest. It is set with WithObserver, same as Observer.
+type DropObserver interface {
+	Observer
+	// OnDrop is called for each dropped element.
+	OnDrop()
+}
+
+// WithBuffer sets the stream buffer capacity. It overrides the default set with WithDefaultBuffer,
+// so WithBuffer(0) makes the step unbuffered.
 func WithBuffer[T any](size int) Option[T] {
-	return func() chan T {
-		return make(chan T, size)
+	return func(o *Options[T]) {
+		o.Buffer = size
+		o.bufferSet = true
+	}
+}
+
+type bufferKey struct{}
+
+// WithDefaultBuffer returns a copy of ctx, which sets the buffer capacity of the steps of pipelines started with it,
+// e.g. FromSlice(WithDefaultBuffer(ctx, 64), ...). The steps without WithBuffer use size,
+// steps with WithBuffer use their own size. Without the default the steps are unbuffered.
+func WithDefaultBuffer(ctx context.Context, size int) context.Context {
+	return context.WithValue(ctx, bufferKey{}, size)
+}
+
+// WithWindow sets the maximum number of elements in flight of the order-preserving parallel steps,
+// like ParMapOrdered and FilterAsync. Processed elements wait in the window until all elements
+// before them are processed, so the window bounds the memory used when a single element is slow.
+func WithWindow[T any](size int) Option[T] {
+	return func(o *Options[T]) {
+		o.specific.window = size
+		o.specific.set |= windowSetting
+	}
+}
+
+// WithGlobalConcurrency limits the total number of elements processed concurrently
+// by all parallel steps (ParMap, ParFilter, ParFilterMap, etc.) of the pipeline to n.
+// For example, ParMap with 100 goroutines followed by ParFilter with 100 goroutines
+// process at most n elements at any given time, instead of 200.
+// Each goroutine holds its share of the budget only while calling the callback.
+// It has effect only when used on a source, like FromSlice or FromIter.
+func WithGlobalConcurrency[T any](n int) Option[T] {
+	return func(o *Options[T]) {
+		o.Concurrency = n
+	}
+}
+
+// WithMaxLineSize sets the maximum length of a line read by FromReader.
+// Lines longer than bufio.MaxScanTokenSize fail with bufio.ErrTooLong, unless a larger size is set.
+func WithMaxLineSize[T any](size int) Option[T] {
+	return func(o *Options[T]) {
+		o.specific.maxLineSize = size
+		o.specific.set |= maxLineSizeSetting
+	}
+}
+
+// WithComma sets the field separator of FromCSV, e.g. '\t' or ';'.
+func WithComma[T any](comma rune) Option[T] {
+	return func(o *Options[T]) {
+		o.specific.comma = comma
+		o.specific.set |= commaSetting
+	}
+}
+
+// WithSkipHeader makes FromCSV skip the first record of the input, e.g. the header with column names.
+func WithSkipHeader[T any]() Option[T] {
+	return func(o *Options[T]) {
+		o.specific.skipHeader = true
+		o.specific.set |= skipHeaderSetting
+	}
+}
+
+// WithObserver sets the observer of the step callback, which is called for each element
+// of the steps with a callback: Map, FilterMap, ParMap, ParFilterMap, ParMapOrdered and the steps built on them,
+// like Filter or ParFilter. The observer does not change the order of elements or the errors of the step.
+func WithObserver[T any](obs Observer) Option[T] {
+	return func(o *Options[T]) {
+		o.Observer = obs
+	}
+}
+
+// WithTracer starts a span named name for each call of the step callback, for the same steps as WithObserver.
+// The span is a child of the span in the pipeline context and the callback receives the context with the span,
+// so spans of the calls made by the callback are nested in it. Errors of the callback are recorded in the span.
+func WithTracer[T any](tracer trace.Tracer, name string) Option[T] {
+	return func(o *Options[T]) {
+		o.Tracer = tracer
+		o.SpanName = name
+	}
+}
+
+// WithBatchPool makes Batch, BatchTimeout and BatchWeighted take the slices for batches from pool,
+// instead of allocating a new slice for each batch. The pool must hold values of type []I, pool.New may be nil. The consumer of the batches owns each batch
+// until it puts the batch back with pool.Put(batch), after which it must not use the batch anymore.
+// A batch which is needed for longer should be copied, or not put back. Slices with capacity less than
+// the batch size are dropped.
+func WithBatchPool[I any](pool *sync.Pool) Option[[]I] {
+	return func(o *Options[[]I]) {
+		o.specific.batchPool = pool
+		o.specific.set |= batchPoolSetting
 	}
 }
+
+// WithErrorHandler sets the handler, which is called once with the error of the step, when the step fails,
+// e.g. for logging or alerting. The handler only observes the error, which is returned from the terminal as usual.
+// It is not called for errors caused by the context cancellation, e.g. when another step fails,
+// unless WithCancelErrors is set.
+func WithErrorHandler[T any](fn func(error)) Option[T] {
+	return func(o *Options[T]) {
+		o.ErrorHandler = fn
+	}
+}
+
+// WithCancelErrors makes the handler set with WithErrorHandler also receive errors caused by the context cancellation.
+func WithCancelErrors[T any]() Option[T] {
+	return func(o *Options[T]) {
+		o.HandleCancel = true
+	}
+}
+
+// applyOptions returns the settings of a step, which uses the accepted specific settings.
+func applyOptions[T any](ops []Option[T], accepted ...setting) Options[T] {
+	var opts Options[T]
+	for _, op := range ops {
+		op(&opts)
+	}
+	for _, s := range accepted {
+		opts.specific.accepted |= s
+	}
+
+	return opts
+}
+
+// option returns Option, which sets all settings to o, to pass the settings to another step.
+func (o Options[T]) option() Option[T] {
+	return func(dst *Options[T]) {
+		*dst = o
+	}
+}
+
+// buffer returns the capacity of the step output channel: Buffer, if it is set,
+// or the default of the pipeline set with WithDefaultBuffer.
+func (o Options[T]) buffer(ctx context.Context) int {
+	if o.bufferSet || o.Buffer != 0 {
+		return o.Buffer
+	}
+
+	size, _ := ctx.Value(bufferKey{}).(int)
+	return size
+}
+
+// newOutput creates an output channel for a step of the pipeline with ctx.
+func newOutput[T any](ctx context.Context, ops []Option[T]) chan T {
+	return make(chan T, applyOptions(ops).buffer(ctx))
+}
+
+type concurrencyKey struct{}
+
+// sourceContext installs source-level options into the pipeline context.
+func sourceContext[T any](ctx context.Context, ops []Option[T]) context.Context {
+	if opts := applyOptions(ops); opts.Concurrency > 0 {
+		ctx = context.WithValue(ctx, concurrencyKey{}, semaphore.NewWeighted(int64(opts.Concurrency)))
+	}
+
+	return ctx
+}
+
+// limited calls fn within the pipeline concurrency budget, if it is set with WithGlobalConcurrency.
+func limited(ctx context.Context, fn func() error) error {
+	sem, ok := ctx.Value(concurrencyKey{}).(*semaphore.Weighted)
+	if !ok {
+		return fn()
+	}
+
+	if err := sem.Acquire(ctx, 1); err != nil {
+		return err
+	}
+	defer sem.Release(1)
+
+	return fn()
+}
+
+// newBatch returns an empty slice for a batch of size elements, taken from the pool,
+// if it is set with WithBatchPool.
+func newBatch[I any](opts Options[[]I], size int) []I {
+	if opts.specific.batchPool != nil {
+		if batch, ok := opts.specific.batchPool.Get().([]I); ok && cap(batch) >= size {
+			return batch[:0]
+		}
+	}
+
+	return make([]I, 0, size)
+}
+
+// invoke calls the step callback fn, reporting to the observer and tracer,
+// if they are set with WithObserver and WithTracer.
+func invoke[T any](ctx context.Context, opts Options[T], fn func(context.Context) error) error {
+	if opts.Observer == nil && opts.Tracer == nil {
+		return fn(ctx)
+	}
+
+	var span trace.Span
+	if opts.Tracer != nil {
+		ctx, span = opts.Tracer.Start(ctx, opts.SpanName)
+	}
+
+	start := time.Now()
+	err := fn(ctx)
+	elapsed := time.Since(start)
+
+	if span != nil {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+
+	if opts.Observer != nil {
+		opts.Observer.OnDuration(elapsed)
+		if err != nil {
+			opts.Observer.OnError(err)
+		} else {
+			opts.Observer.OnElement()
+		}
+	}
+
+	return err
+}
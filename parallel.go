@@ -2,6 +2,8 @@ package rheos
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
 
 	"golang.org/x/sync/errgroup"
 )
@@ -9,43 +11,73 @@ import (
 // ParFilterMap is like FilterMap, but runs the mapping and filtering operations concurrently with num goroutines.
 // The order of the output elements is undefined.
 // It's better to use it with a buffered stream.
+// If callback returns error or context is cancelled during processing, ParFilterMap stops processing and returns error.
+// Terminals return the error which occurs first in the pipeline, whether it is returned by callback,
+// by another step or is the cancellation of the context: an error of callback stops the whole pipeline immediately,
+// without waiting for the callbacks still running in the other goroutines, and errors occurring after it are ignored.
 func ParFilterMap[I any, O any](pipe Stream[I], num int, callback func(context.Context, I) (O, bool, error), ops ...Option[O]) Stream[O] {
-	output := make(chan O)
-	for _, op := range ops {
-		output = op()
+	opts := applyOptions(ops)
+	output := make(chan O, opts.buffer(pipe.ctx))
+	stopper := newStopper()
+
+	workerOpts := opts
+	if handler := opts.ErrorHandler; handler != nil {
+		var once sync.Once
+		workerOpts.ErrorHandler = func(err error) { once.Do(func() { handler(err) }) } // once for all workers
 	}
 
-	eg, ctx := errgroup.WithContext(pipe.ctx)
-	pipe.eg.Go(func() error { // goroutine which spawns more goroutines
-		defer close(output)
+	// workers report errors directly to the pipeline, so the first error is not delayed by slow callbacks
+	var workers sync.WaitGroup
+	for i := 0; i < num; i++ {
+		workers.Add(1)
+		goStep(pipe.eg, pipe.ctx, "ParFilterMap", workerOpts, func() error {
+			defer workers.Done()
 
-		for i := 0; i < num; i++ {
-			eg.Go(func() error {
-				for elem := range pipe.in {
-					mapped, ok, err := callback(ctx, elem)
-					if err != nil {
-						return err
-					}
-					if !ok {
-						continue
-					}
+			for elem := range pipe.in {
+				var (
+					mapped O
+					ok     bool
+				)
+				err := limited(pipe.ctx, func() error {
+					return invoke(pipe.ctx, opts, func(ctx context.Context) (err error) {
+						mapped, ok, err = callback(ctx, elem)
 
-					if err := push(ctx, output, mapped); err != nil {
+						return
+					})
+				})
+				if err != nil {
+					return err
+				}
+				if !ok {
+					if err := dropped(stopper); err != nil {
 						return err
 					}
+
+					continue
 				}
 
-				return nil
-			})
-		}
+				if err := emit(pipe.ctx, stopper, output, mapped); err != nil {
+					return err
+				}
+			}
 
-		return eg.Wait()
+			return nil
+		})
+	}
+
+	pipe.eg.Go(func() error { // closes the output, when all workers end
+		workers.Wait()
+		close(output)
+		pipe.stop()
+
+		return nil
 	})
 
 	return Stream[O]{
-		in:  output,
-		eg:  pipe.eg,
-		ctx: pipe.ctx,
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
 	}
 }
 
@@ -80,3 +112,413 @@ func ParFilter[I any](pipe Stream[I], num int, callback func(context.Context, I)
 		ops...,
 	)
 }
+
+// ParMapCollectErrors is like ParMap, but errors returned by mapper do not stop processing:
+// the element is dropped and the error is recorded. Recorded errors are returned by the returned function,
+// which should be called after the stream is consumed.
+// The order of the output elements is undefined.
+// If context is cancelled during processing, ParMapCollectErrors stops processing and returns error.
+func ParMapCollectErrors[I any, O any](pipe Stream[I], num int, mapper func(context.Context, I) (O, error), ops ...Option[O]) (Stream[O], func() []error) {
+	var (
+		mu   sync.Mutex
+		errs []error
+	)
+
+	stream := ParFilterMap[I, O](
+		pipe,
+		num,
+		func(ctx context.Context, elem I) (O, bool, error) {
+			mapped, err := mapper(ctx, elem)
+			if err == nil {
+				return mapped, true, nil
+			}
+			if ctx.Err() != nil {
+				return mapped, false, ctx.Err()
+			}
+
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+
+			return mapped, false, nil
+		},
+		ops...,
+	)
+
+	return stream, func() []error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return append([]error(nil), errs...)
+	}
+}
+
+// ParForEach is like ForEach, but calls callback concurrently with num goroutines.
+// The order of processing is undefined. If num is less than 1, a single goroutine is used.
+// If callback returns error or context is cancelled during processing, ParForEach stops, waits for
+// the running callbacks to return and returns *PipelineError with the first error.
+func ParForEach[I any](pipe Stream[I], num int, callback func(context.Context, I) error) error {
+	if num < 1 {
+		num = 1
+	}
+
+	var processed int64
+	for i := 0; i < num; i++ {
+		goStep(pipe.eg, pipe.ctx, "ParForEach", Options[I]{}, func() error {
+			for elem := range pipe.in {
+				if pipe.ctx.Err() != nil {
+					return pipe.ctx.Err()
+				}
+
+				if err := limited(pipe.ctx, func() error { return callback(pipe.ctx, elem) }); err != nil {
+					return err
+				}
+				atomic.AddInt64(&processed, 1)
+			}
+
+			return nil
+		})
+	}
+
+	err := pipe.eg.Wait()
+
+	return newPipelineError(pipe.ctx, err, int(atomic.LoadInt64(&processed)))
+}
+
+// ParMapPriority is like ParMap, but workers take the highest-priority element available first.
+// Incoming elements are read eagerly into a priority queue, so all pending elements are held in memory.
+// Elements with the same priority are processed in order of arrival.
+// To avoid starvation of low-priority elements under constant urgent work, waiting elements age:
+// an element with priority p is taken before elements with priority p+1 which arrived 100 or more elements after it,
+// before elements with priority p+2 which arrived 200 or more elements after it, and so on.
+// The order of the output elements is undefined.
+// If error occurs or context is cancelled during processing, ParMapPriority stops processing and returns error.
+func ParMapPriority[I any, O any](pipe Stream[I], num int, priority func(I) int, mapper func(context.Context, I) (O, error), ops ...Option[O]) Stream[O] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	eg, ctx := errgroup.WithContext(pipe.ctx)
+	goStep(pipe.eg, pipe.ctx, "ParMapPriority", applyOptions(ops), func() error { // goroutine which spawns more goroutines
+		defer close(output)
+		defer pipe.stop()
+
+		jobs := make(chan I)
+		eg.Go(func() error { // dispatcher
+			defer close(jobs)
+
+			const aging = 100 // arrivals after which waiting elements gain one priority level
+			queue := newLessHeap(func(a, b prioritized[I]) bool {
+				if a.rank == b.rank {
+					return a.seq < b.seq
+				}
+
+				return a.rank > b.rank
+			})
+			var seq int
+			input := pipe.in
+			for input != nil || queue.Len() > 0 {
+				var (
+					next chan<- I
+					head I
+				)
+				if queue.Len() > 0 {
+					next, head = jobs, queue.peek().elem
+				}
+
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case elem, ok := <-input:
+					if !ok {
+						input = nil
+
+						continue
+					}
+					rank := float64(priority(elem)) - float64(seq)/aging
+					queue.push(prioritized[I]{elem: elem, rank: rank, seq: seq})
+					seq++
+				case next <- head:
+					queue.pop()
+				}
+			}
+
+			return nil
+		})
+
+		for i := 0; i < num; i++ {
+			eg.Go(func() error {
+				for elem := range jobs {
+					var mapped O
+					err := limited(ctx, func() (err error) {
+						mapped, err = mapper(ctx, elem)
+
+						return
+					})
+					if err != nil {
+						return err
+					}
+
+					if err := emit(ctx, stopper, output, mapped); err != nil {
+						return err
+					}
+				}
+
+				return nil
+			})
+		}
+
+		return eg.Wait()
+	})
+
+	return Stream[O]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+type prioritized[I any] struct {
+	elem I
+	rank float64 // priority lowered by the order of arrival
+	seq  int     // order of arrival
+}
+
+// ParMapOrdered is like ParMap, but emits the results in order of the input elements.
+// It runs the mapping operations concurrently with num goroutines. Results which are ready before
+// the results of the preceding elements wait until those are emitted, so at most 2*num elements are in flight.
+// The window can be changed with WithWindow: a larger window keeps workers busy when processing time varies,
+// a smaller one bounds the memory used while a single slow element stalls the stream.
+// If error occurs or context is cancelled during processing, ParMapOrdered stops processing and returns error.
+func ParMapOrdered[I any, O any](pipe Stream[I], num int, mapper func(context.Context, I) (O, error), ops ...Option[O]) Stream[O] {
+	return parFilterMapOrdered[I, O](
+		pipe,
+		"ParMapOrdered",
+		num,
+		2*num,
+		func(ctx context.Context, elem I) (O, bool, error) {
+			mapped, err := mapper(ctx, elem)
+
+			return mapped, true, err
+		},
+		ops...,
+	)
+}
+
+// FilterAsync is like ParFilter, but preserves the order of the elements.
+// It runs the filtering operations concurrently with num goroutines,
+// which suits callback doing slow asynchronous calls, like remote authorization checks.
+// To keep the order it holds at most 2*num elements in flight (see WithWindow), so a single slow element
+// stalls the stream until it is processed.
+// If error occurs or context is cancelled during processing, FilterAsync stops processing and returns error.
+func FilterAsync[I any](pipe Stream[I], num int, pred func(context.Context, I) (bool, error), ops ...Option[I]) Stream[I] {
+	return parFilterMapOrdered[I, I](
+		pipe,
+		"FilterAsync",
+		num,
+		2*num,
+		func(ctx context.Context, elem I) (I, bool, error) {
+			ok, err := pred(ctx, elem)
+
+			return elem, ok, err
+		},
+		ops...,
+	)
+}
+
+// ParBatchMap groups elements of the stream into batches of size elements, like Batch,
+// maps the batches concurrently with num goroutines and emits the elements of the mapped batches,
+// in order of the input. It suits mappers with a heavy per-batch work, like bulk requests.
+// The last batch may hold fewer elements. Size less than 1 is treated as 1.
+// Options configure the mapping of batches, so they are typed by the mapped batch: WithWindow limits the batches
+// in flight and the observer set with WithObserver is called once per batch.
+// If error occurs or context is cancelled during processing, ParBatchMap stops processing and returns error.
+func ParBatchMap[I any, O any](pipe Stream[I], size, num int, mapper func(context.Context, []I) ([]O, error), ops ...Option[[]O]) Stream[O] {
+	if size < 1 {
+		size = 1
+	}
+
+	mapped := parFilterMapOrdered[[]I, []O](
+		Batch(pipe, size),
+		"ParBatchMap",
+		num,
+		2*num,
+		func(ctx context.Context, batch []I) ([]O, bool, error) {
+			out, err := mapper(ctx, batch)
+
+			return out, true, err
+		},
+		ops...,
+	)
+
+	return Flatten[[]O](mapped)
+}
+
+type orderedJob[I any, O any] struct {
+	elem   I
+	result chan orderedResult[O]
+}
+
+type orderedResult[O any] struct {
+	value O
+	ok    bool
+}
+
+// parFilterMapOrdered runs callback concurrently with num goroutines, emitting results in the input order.
+// At most window elements are in flight, unless the window is set with WithWindow.
+func parFilterMapOrdered[I any, O any](pipe Stream[I], name string, num, window int, callback func(context.Context, I) (O, bool, error), ops ...Option[O]) Stream[O] {
+	opts := applyOptions(ops, windowSetting)
+	output := make(chan O, opts.buffer(pipe.ctx))
+	stopper := newStopper()
+	if size := opts.specific.window; size > 0 {
+		window = size
+	}
+	if window < 1 {
+		window = 1
+	}
+
+	eg, ctx := errgroup.WithContext(pipe.ctx)
+	goStep(pipe.eg, pipe.ctx, name, opts, func() error { // goroutine which spawns more goroutines
+		defer close(output)
+		defer pipe.stop()
+
+		jobs := make(chan orderedJob[I, O])
+		// collector waits for one result, the rest is buffered, so window elements are in flight
+		pending := make(chan chan orderedResult[O], window-1)
+
+		eg.Go(func() error { // dispatcher
+			defer close(jobs)
+			defer close(pending)
+
+			for elem := range pipe.in {
+				result := make(chan orderedResult[O], 1)
+				if err := push(ctx, pending, result); err != nil {
+					return err
+				}
+				if err := push(ctx, jobs, orderedJob[I, O]{elem: elem, result: result}); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+
+		for i := 0; i < num; i++ {
+			eg.Go(func() error {
+				for job := range jobs {
+					var (
+						mapped O
+						ok     bool
+					)
+					err := limited(ctx, func() error {
+						return invoke(ctx, opts, func(ctx context.Context) (err error) {
+							mapped, ok, err = callback(ctx, job.elem)
+
+							return
+						})
+					})
+					if err != nil {
+						return err
+					}
+
+					job.result <- orderedResult[O]{value: mapped, ok: ok} // buffered, never blocks
+				}
+
+				return nil
+			})
+		}
+
+		eg.Go(func() error { // collector
+			for result := range pending {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case res := <-result:
+					if !res.ok {
+						if err := dropped(stopper); err != nil {
+							return err
+						}
+
+						continue
+					}
+
+					if err := emit(ctx, stopper, output, res.value); err != nil {
+						return err
+					}
+				}
+			}
+
+			return nil
+		})
+
+		return eg.Wait()
+	})
+
+	return Stream[O]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// Pool is a worker pool, which runs submitted tasks.
+// Submit may block until the pool has capacity to run the task.
+type Pool interface {
+	Submit(task func())
+}
+
+// RunInPool processes each element in the stream using the given callback function,
+// submitting it as a task to the pool instead of spawning its own goroutines.
+// This allows to manage goroutine budget of the application centrally.
+// The order of processing is undefined. RunInPool waits for all submitted tasks to finish before returning.
+// If callback returns error or context is cancelled during processing, RunInPool stops and returns *PipelineError.
+func RunInPool[I any](pipe Stream[I], pool Pool, callback func(context.Context, I) error) error {
+	var processed int64
+	goStep(pipe.eg, pipe.ctx, "RunInPool", Options[I]{}, func() error {
+		ctx, cancel := context.WithCancel(pipe.ctx)
+		defer cancel()
+
+		var (
+			wg       sync.WaitGroup
+			once     sync.Once
+			firstErr error
+		)
+
+	loop:
+		for {
+			select {
+			case <-ctx.Done():
+				break loop
+			case elem, ok := <-pipe.in:
+				if !ok {
+					break loop
+				}
+
+				wg.Add(1)
+				pool.Submit(func() {
+					defer wg.Done()
+
+					if err := callback(ctx, elem); err != nil {
+						once.Do(func() {
+							firstErr = err
+							cancel()
+						})
+
+						return
+					}
+					atomic.AddInt64(&processed, 1)
+				})
+			}
+		}
+
+		wg.Wait()
+		if firstErr != nil {
+			return firstErr
+		}
+
+		return pipe.ctx.Err()
+	})
+
+	return newPipelineError(pipe.ctx, pipe.eg.Wait(), int(processed))
+}
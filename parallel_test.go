@@ -38,6 +38,156 @@ func TestParallel(t *testing.T) {
 	}
 }
 
+func TestParFilterStopped(t *testing.T) {
+	var produced int64
+	filtered := rheos.ParFilter(newInfiniteProducer(context.Background(), &produced), 4, func(_ context.Context, v int) (bool, error) {
+		return v == 5, nil // no more matches after the first one
+	})
+	got, err := rheos.Collect(rheos.Take(filtered, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSlicesEqual(t, []int{5}, got)
+}
+
+func TestParFilterMapErrorPrecedence(t *testing.T) {
+	errUpstream := errors.New("upstream")
+
+	t.Run("callback error before upstream error", func(t *testing.T) {
+		release := make(chan struct{})
+		time.AfterFunc(100*time.Millisecond, func() { close(release) })
+
+		src := rheos.FromIter(context.Background(), func(yield func(int) bool) error {
+			yield(0)
+			yield(1)
+			time.Sleep(50 * time.Millisecond)
+			return errUpstream
+		})
+		mapped := rheos.ParMap(src, 2, func(_ context.Context, v int) (int, error) {
+			if v == 0 {
+				<-release // slow callback, ignoring the context
+				return v, nil
+			}
+			return 0, errTest
+		})
+
+		_, err := rheos.Collect(mapped)
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+		var pipeErr *rheos.PipelineError
+		if errors.As(err, &pipeErr) && pipeErr.Step != "ParFilterMap" {
+			t.Errorf("unexpected step: %q, want: ParFilterMap", pipeErr.Step)
+		}
+	})
+
+	t.Run("upstream error before callback error", func(t *testing.T) {
+		src := rheos.FromIter(context.Background(), func(yield func(int) bool) error {
+			yield(0)
+			return errUpstream
+		})
+		mapped := rheos.ParMap(src, 2, func(ctx context.Context, v int) (int, error) {
+			<-ctx.Done()
+			return 0, errTest // not a cancellation error, but occurs later
+		})
+
+		_, err := rheos.Collect(mapped)
+		if !errors.Is(err, errUpstream) {
+			t.Errorf("unexpected error: %v, want: %v", err, errUpstream)
+		}
+	})
+
+	t.Run("cancellation before callback error", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var produced int64
+		mapped := rheos.ParMap(newInfiniteProducer(ctx, &produced), 2, func(ctx context.Context, v int) (int, error) {
+			if v == 0 {
+				cancel()
+			}
+			<-ctx.Done()
+			time.Sleep(20 * time.Millisecond)
+			return 0, errTest
+		})
+
+		_, err := rheos.Collect(mapped)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+
+	t.Run("error handler called once", func(t *testing.T) {
+		var handled int64
+		mapped := rheos.ParMap(newProducer(context.Background(), 10), 4, func(context.Context, int) (int, error) {
+			time.Sleep(time.Millisecond)
+			return 0, errTest // every worker fails
+		}, rheos.WithErrorHandler[int](func(error) { atomic.AddInt64(&handled, 1) }))
+
+		_, err := rheos.Collect(mapped)
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+		if h := atomic.LoadInt64(&handled); h != 1 {
+			t.Errorf("handler called %d times, want 1", h)
+		}
+	})
+}
+
+func TestParForEach(t *testing.T) {
+	t.Run("runs concurrently", func(t *testing.T) {
+		var sum int64
+		start := time.Now()
+		err := rheos.ParForEach(newProducer(context.Background(), 10), 10, func(_ context.Context, v int) error {
+			time.Sleep(100 * time.Millisecond)
+			atomic.AddInt64(&sum, int64(v))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sum != 45 {
+			t.Errorf("got sum %d, want 45", sum)
+		}
+		if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+			t.Errorf("elapsed time %s, want less than 200ms", elapsed)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		var running int64
+		err := rheos.ParForEach(newProducer(context.Background(), 100), 4, func(ctx context.Context, v int) error {
+			atomic.AddInt64(&running, 1)
+			defer atomic.AddInt64(&running, -1)
+			if v == 10 {
+				return errTest
+			}
+			time.Sleep(time.Millisecond)
+			return nil
+		})
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+		var pipeErr *rheos.PipelineError
+		if !errors.As(err, &pipeErr) {
+			t.Errorf("got %T, want *rheos.PipelineError", err)
+		}
+		if r := atomic.LoadInt64(&running); r != 0 {
+			t.Errorf("%d callbacks still running after return", r)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := rheos.ParForEach(newProducer(ctx, 10), 4, func(context.Context, int) error { return nil })
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
 func TestParallelPipeline(t *testing.T) {
 	testFn := func(producer rheos.Stream[int], mapFn func(context.Context, int) (int, error), filterMapFn func(context.Context, int) (int, bool, error)) ([]int, error) {
 		size := rand.Intn(10) + 1
@@ -141,3 +291,476 @@ func TestParallelPipeline(t *testing.T) {
 		}
 	})
 }
+
+type testPool struct {
+	tasks chan func()
+}
+
+func newTestPool(t *testing.T, workers int) *testPool {
+	pool := &testPool{tasks: make(chan func())}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for task := range pool.tasks {
+				task()
+			}
+		}()
+	}
+	t.Cleanup(func() { close(pool.tasks) })
+
+	return pool
+}
+
+func (p *testPool) Submit(task func()) {
+	p.tasks <- task
+}
+
+func TestRunInPool(t *testing.T) {
+	t.Run("processes all", func(t *testing.T) {
+		num := int(rand.Int31n(100) + 10)
+		var sum int64
+		err := rheos.RunInPool(newProducer(context.Background(), num), newTestPool(t, 4), func(_ context.Context, v int) error {
+			atomic.AddInt64(&sum, int64(v))
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if want := int64(num * (num - 1) / 2); sum != want {
+			t.Errorf("sum %d, want %d", sum, want)
+		}
+	})
+
+	t.Run("callback error", func(t *testing.T) {
+		num := int(rand.Int31n(100) + 10)
+		err := rheos.RunInPool(newProducer(context.Background(), num), newTestPool(t, 4), func(_ context.Context, v int) error {
+			if v >= num/2 {
+				return errTest
+			}
+			return nil
+		})
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := rheos.RunInPool(newProducer(ctx, 10), newTestPool(t, 4), func(_ context.Context, v int) error {
+			return nil
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestParMapPriority(t *testing.T) {
+	// blocked runs the elements through ParMapPriority with a single worker,
+	// which is blocked on the first taken element until all elements are queued.
+	blocked := func(t *testing.T, elems []int, priority func(int) int) []int {
+		t.Helper()
+
+		queued := make(chan struct{})
+		src := rheos.FromIter(context.Background(), func(yield func(int) bool) error {
+			defer close(queued)
+			for _, elem := range elems {
+				if !yield(elem) {
+					return nil
+				}
+			}
+			return nil
+		})
+
+		first := true
+		mapped := rheos.ParMapPriority(src, 1, priority, func(_ context.Context, v int) (int, error) {
+			if first {
+				first = false
+				<-queued
+			}
+			return v, nil
+		})
+		got, err := rheos.Collect(mapped)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		return got
+	}
+
+	t.Run("highest priority first", func(t *testing.T) {
+		got := blocked(t, []int{0, 1, 3, 2, 5, 4}, func(v int) int { return v })
+		if len(got) != 6 {
+			t.Fatalf("got %d elements, want 6", len(got))
+		}
+		// the first element is taken before the rest are queued
+		if !sort.SliceIsSorted(got[1:], func(i, j int) bool { return got[1+i] > got[1+j] }) {
+			t.Errorf("queued elements processed in order %v, want by priority", got[1:])
+		}
+	})
+
+	t.Run("same priority in order of arrival", func(t *testing.T) {
+		got := blocked(t, intRange(10), func(int) int { return 0 })
+		assertSlicesEqual(t, intRange(10), got)
+	})
+
+	t.Run("low priority is not starved", func(t *testing.T) {
+		num := 500
+		low := -1
+		elems := append([]int{0, low}, intRange(num)[1:]...)
+		got := blocked(t, elems, func(v int) int {
+			if v == low {
+				return 0
+			}
+			return 1
+		})
+
+		if len(got) != len(elems) {
+			t.Fatalf("got %d elements, want %d", len(got), len(elems))
+		}
+		for i, v := range got {
+			if v == low && i > 200 {
+				t.Errorf("low priority element processed at %d, want before 200", i)
+			}
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		mapped := rheos.ParMapPriority(newProducer(context.Background(), 10), 3, func(v int) int { return v }, func(_ context.Context, v int) (int, error) {
+			if v == 5 {
+				return 0, errTest
+			}
+			return v, nil
+		})
+		_, err := rheos.Collect(mapped)
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		mapped := rheos.ParMapPriority(newProducer(ctx, 10), 3, func(v int) int { return v }, func(_ context.Context, v int) (int, error) {
+			return v, nil
+		})
+		_, err := rheos.Collect(mapped)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestParMapOrdered(t *testing.T) {
+	t.Run("preserves order", func(t *testing.T) {
+		num := 50
+		mapped := rheos.ParMapOrdered(newProducer(context.Background(), num), 5, func(_ context.Context, v int) (string, error) {
+			time.Sleep(time.Duration(rand.Intn(3)) * time.Millisecond) // simulate work
+			return strconv.Itoa(v), nil
+		})
+
+		got, err := rheos.Collect(mapped)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := make([]string, num)
+		for i := range want {
+			want[i] = strconv.Itoa(i)
+		}
+		assertSlicesEqual(t, want, got)
+	})
+
+	t.Run("window bounds elements in flight", func(t *testing.T) {
+		var started int64
+		release := make(chan struct{})
+		mapped := rheos.ParMapOrdered(newProducer(context.Background(), 20), 10, func(_ context.Context, v int) (int, error) {
+			atomic.AddInt64(&started, 1)
+			if v == 0 {
+				<-release // slow element
+			}
+			return v, nil
+		}, rheos.WithWindow[int](3))
+
+		done := make(chan error)
+		var got []int
+		go func() {
+			var err error
+			got, err = rheos.Collect(mapped)
+			done <- err
+		}()
+
+		time.Sleep(20 * time.Millisecond)
+		if s := atomic.LoadInt64(&started); s > 3 {
+			t.Errorf("started %d elements while the first one is processed, want at most 3", s)
+		}
+		close(release)
+
+		if err := <-done; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(20), got)
+	})
+
+	t.Run("mapper error", func(t *testing.T) {
+		mapped := rheos.ParMapOrdered(newProducer(context.Background(), 20), 4, func(_ context.Context, v int) (int, error) {
+			if v == 10 {
+				return 0, errTest
+			}
+			return v, nil
+		})
+		_, err := rheos.Collect(mapped)
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		mapped := rheos.ParMapOrdered(newProducer(ctx, 10), 4, func(_ context.Context, v int) (int, error) {
+			return v, nil
+		})
+		_, err := rheos.Collect(mapped)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestParBatchMap(t *testing.T) {
+	t.Run("maps batches in order", func(t *testing.T) {
+		num := 53
+		var batches int64
+		mapped := rheos.ParBatchMap(newProducer(context.Background(), num), 5, 4, func(_ context.Context, batch []int) ([]string, error) {
+			atomic.AddInt64(&batches, 1)
+			if len(batch) > 5 {
+				t.Errorf("got batch of %d elements, want at most 5", len(batch))
+			}
+			time.Sleep(time.Duration(rand.Intn(3)) * time.Millisecond) // simulate work
+			out := make([]string, len(batch))
+			for i, v := range batch {
+				out[i] = strconv.Itoa(v)
+			}
+			return out, nil
+		})
+
+		got, err := rheos.Collect(mapped)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := make([]string, num)
+		for i := range want {
+			want[i] = strconv.Itoa(i)
+		}
+		assertSlicesEqual(t, want, got)
+		if b := atomic.LoadInt64(&batches); b != 11 {
+			t.Errorf("mapped %d batches, want 11", b)
+		}
+	})
+
+	t.Run("options of batches", func(t *testing.T) {
+		obs := &countingObserver{}
+		mapped := rheos.ParBatchMap(newProducer(context.Background(), 10), 3, 2, func(_ context.Context, batch []int) ([]int, error) {
+			return batch, nil
+		}, rheos.WithObserver[[]int](obs), rheos.WithWindow[[]int](1))
+
+		got, err := rheos.Collect(mapped)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(10), got)
+		if n := atomic.LoadInt64(&obs.elements); n != 4 {
+			t.Errorf("observed %d calls, want 4 batches", n)
+		}
+	})
+
+	t.Run("mapper error", func(t *testing.T) {
+		mapped := rheos.ParBatchMap(newProducer(context.Background(), 20), 3, 2, func(_ context.Context, batch []int) ([]int, error) {
+			if batch[0] == 9 {
+				return nil, errTest
+			}
+			return batch, nil
+		})
+		_, err := rheos.Collect(mapped)
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+		var pipeErr *rheos.PipelineError
+		if errors.As(err, &pipeErr) && pipeErr.Step != "ParBatchMap" {
+			t.Errorf("unexpected step: %q, want: ParBatchMap", pipeErr.Step)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		mapped := rheos.ParBatchMap(newProducer(ctx, 10), 3, 2, func(_ context.Context, batch []int) ([]int, error) {
+			return batch, nil
+		})
+		_, err := rheos.Collect(mapped)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestFilterAsync(t *testing.T) {
+	t.Run("preserves order", func(t *testing.T) {
+		num := 50
+		p := newProducer(context.Background(), num)
+		even := rheos.FilterAsync(p, 5, func(_ context.Context, v int) (bool, error) {
+			time.Sleep(time.Duration(rand.Intn(3)) * time.Millisecond) // simulate remote call
+			return v%2 == 0, nil
+		})
+
+		got, err := rheos.Collect(even)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		want := make([]int, 0, num/2)
+		for i := 0; i < num; i += 2 {
+			want = append(want, i)
+		}
+		assertSlicesEqual(t, want, got)
+	})
+
+	t.Run("runs concurrently", func(t *testing.T) {
+		start := time.Now()
+		p := newProducer(context.Background(), 10)
+		filtered := rheos.FilterAsync(p, 10, func(_ context.Context, v int) (bool, error) {
+			time.Sleep(100 * time.Millisecond)
+			return true, nil
+		})
+		got, err := rheos.Collect(filtered)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(10), got)
+
+		if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+			t.Errorf("elapsed time %s, want less than 200ms", elapsed)
+		}
+	})
+
+	t.Run("predicate error", func(t *testing.T) {
+		num := int(rand.Int31n(100) + 10)
+		p := newProducer(context.Background(), num)
+		filtered := rheos.FilterAsync(p, 4, func(_ context.Context, v int) (bool, error) {
+			if v >= num/2 {
+				return false, errTest
+			}
+			return true, nil
+		})
+		_, err := rheos.Collect(filtered)
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+
+	t.Run("stopped by consumer", func(t *testing.T) {
+		var produced int64
+		filtered := rheos.FilterAsync(newInfiniteProducer(context.Background(), &produced), 4, func(_ context.Context, v int) (bool, error) {
+			return v == 5, nil // no more matches after the first one
+		})
+		got, err := rheos.Collect(rheos.Take(filtered, 1))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{5}, got)
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		filtered := rheos.FilterAsync(newProducer(ctx, 10), 4, func(_ context.Context, v int) (bool, error) {
+			return true, nil
+		})
+		_, err := rheos.Collect(filtered)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestParMapCollectErrors(t *testing.T) {
+	t.Run("collects errors", func(t *testing.T) {
+		num := 20
+		p := newProducer(context.Background(), num)
+		mapped, errs := rheos.ParMapCollectErrors(p, 4, func(_ context.Context, v int) (int, error) {
+			if v%5 == 0 {
+				return 0, errTest
+			}
+			return v, nil
+		})
+
+		got, err := rheos.Collect(mapped)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != num-4 {
+			t.Errorf("got %d elements, want %d", len(got), num-4)
+		}
+		collected := errs()
+		if len(collected) != 4 {
+			t.Fatalf("got %d errors, want 4", len(collected))
+		}
+		for _, err := range collected {
+			if !errors.Is(err, errTest) {
+				t.Errorf("unexpected error: %v, want: %v", err, errTest)
+			}
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		p := newProducer(ctx, 100)
+		mapped, _ := rheos.ParMapCollectErrors(p, 4, func(_ context.Context, v int) (int, error) {
+			if v == 10 {
+				cancel()
+			}
+			return v, nil
+		})
+
+		_, err := rheos.Collect(mapped)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestWithGlobalConcurrency(t *testing.T) {
+	var running, maxRunning int32
+	work := func(_ context.Context, v int) (int, error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond) // simulate work
+		return v, nil
+	}
+
+	num := 50
+	p := rheos.FromSlice(context.Background(), intRange(num), rheos.WithGlobalConcurrency[int](3))
+	step1 := rheos.ParMap(p, 10, work)
+	step2 := rheos.ParMap(step1, 10, work)
+	got, err := rheos.Collect(step2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != num {
+		t.Errorf("got %d elements, want %d", len(got), num)
+	}
+	if m := atomic.LoadInt32(&maxRunning); m > 3 {
+		t.Errorf("max %d elements processed concurrently, want at most 3", m)
+	}
+}
@@ -2,17 +2,64 @@
 package rheos
 
 import (
+	"container/list"
 	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"golang.org/x/sync/errgroup"
 )
 
+// ErrOutOfOrder is returned by EnforceMonotonic when an element arrives too far out of order.
+var ErrOutOfOrder = errors.New("element is out of order")
+
+// ErrZeroStep is returned by Range when step is zero.
+var ErrZeroStep = errors.New("range step must not be zero")
+
+// ErrInvalidSample is returned by Sample when n is not positive.
+var ErrInvalidSample = errors.New("sample n must be positive")
+
 // Stream is a base element of data steam processing pipeline.
 type Stream[I any] struct {
-	in  <-chan I
-	eg  *errgroup.Group
-	ctx context.Context
+	in      <-chan I
+	eg      *errgroup.Group
+	ctx     context.Context
+	stopper *stopper // stops the step producing the stream, when no more elements are needed
+}
+
+// stop tells the step producing the stream that no more elements are needed.
+func (s Stream[I]) stop() {
+	s.stopper.stop()
+}
+
+// Context returns the context of the pipeline, which is cancelled when any step fails.
+// It can be used to derive contexts for work related to the pipeline.
+func (s Stream[I]) Context() context.Context {
+	return s.ctx
+}
+
+// Wait waits for all steps of the pipeline to finish and returns the first error of the steps, if any.
+// Terminals, like Collect, wait for the pipeline themselves. Wait is for consuming the stream
+// without a terminal, e.g. with All, and must be called after the stream is fully consumed,
+// otherwise it blocks forever, as steps wait for their elements to be received. Use Close for a stream
+// which is consumed only partially.
+func (s Stream[I]) Wait() error {
+	return s.eg.Wait()
+}
+
+// Close stops the stream, which is not consumed until the end, e.g. when its elements are no longer needed,
+// and waits for all steps of the pipeline to finish, same as Wait. The steps are stopped as with Take,
+// so they end without error and release their goroutines. Close returns the first error of the steps,
+// if the pipeline failed before it was stopped. The elements left in the stream are dropped.
+func (s Stream[I]) Close() error {
+	s.stop()
+
+	return s.eg.Wait()
 }
 
 // Iter is an iterator over sequences of individual values.
@@ -24,18 +71,16 @@ type Iter[T any] func(yield func(T) bool) error
 // If seq returns error or context is cancelled during processing,
 // Stream stops processing and returns error.
 func FromIter[I any](ctx context.Context, iter Iter[I], ops ...Option[I]) Stream[I] {
-	results := make(chan I)
-	for _, op := range ops {
-		results = op()
-	}
+	results := newOutput(ctx, ops)
+	stopper := newStopper()
 
-	eg, ctx := errgroup.WithContext(ctx)
-	eg.Go(func() error {
+	eg, ctx := errgroup.WithContext(sourceContext(ctx, ops))
+	goStep(eg, ctx, "FromIter", applyOptions(ops), func() error {
 		defer close(results)
 
 		var err error
 		pushFn := func(elem I) bool {
-			err = push(ctx, results, elem)
+			err = emit(ctx, stopper, results, elem)
 			return err == nil
 		}
 
@@ -47,9 +92,10 @@ func FromIter[I any](ctx context.Context, iter Iter[I], ops ...Option[I]) Stream
 	})
 
 	return Stream[I]{
-		in:  results,
-		eg:  eg,
-		ctx: ctx,
+		in:      results,
+		eg:      eg,
+		ctx:     ctx,
+		stopper: stopper,
 	}
 }
 
@@ -72,17 +118,15 @@ func FromSlice[I any](ctx context.Context, slice []I, ops ...Option[I]) Stream[I
 // FromChannel creates a new Stream from a channel.
 // If context is cancelled during processing, Stream stops processing and returns error.
 func FromChannel[I any](ctx context.Context, input <-chan I, ops ...Option[I]) Stream[I] {
-	results := make(chan I)
-	for _, op := range ops {
-		results = op()
-	}
+	results := newOutput(ctx, ops)
+	stopper := newStopper()
 
-	eg, ctx := errgroup.WithContext(ctx)
-	eg.Go(func() error {
+	eg, ctx := errgroup.WithContext(sourceContext(ctx, ops))
+	goStep(eg, ctx, "FromChannel", applyOptions(ops), func() error {
 		defer close(results)
 
 		for elem := range input {
-			if err := push(ctx, results, elem); err != nil {
+			if err := emit(ctx, stopper, results, elem); err != nil {
 				return err
 			}
 		}
@@ -91,30 +135,159 @@ func FromChannel[I any](ctx context.Context, input <-chan I, ops ...Option[I]) S
 	})
 
 	return Stream[I]{
-		in:  results,
-		eg:  eg,
-		ctx: ctx,
+		in:      results,
+		eg:      eg,
+		ctx:     ctx,
+		stopper: stopper,
+	}
+}
+
+// Result holds a value or an error, received by FromChannel2.
+type Result[I any] struct {
+	Value I
+	Err   error
+}
+
+// FromChannel2 creates a new Stream from a channel of results, like FromChannel,
+// e.g. to receive the results of a pool of workers. Values of the results are emitted until the channel is closed.
+// If a result holds error, Stream stops processing and returns the error, the value of the result is not emitted.
+// If context is cancelled during processing, Stream stops processing and returns error.
+func FromChannel2[I any](ctx context.Context, input <-chan Result[I], ops ...Option[I]) Stream[I] {
+	results := newOutput(ctx, ops)
+	stopper := newStopper()
+
+	eg, ctx := errgroup.WithContext(sourceContext(ctx, ops))
+	goStep(eg, ctx, "FromChannel2", applyOptions(ops), func() error {
+		defer close(results)
+
+		for {
+			result, ok, err := pull(ctx, input)
+			if err != nil || !ok {
+				return err
+			}
+			if result.Err != nil {
+				return result.Err
+			}
+
+			if err := emit(ctx, stopper, results, result.Value); err != nil {
+				return err
+			}
+		}
+	})
+
+	return Stream[I]{
+		in:      results,
+		eg:      eg,
+		ctx:     ctx,
+		stopper: stopper,
+	}
+}
+
+// Generate creates a new Stream of elements returned by gen. Generate calls gen repeatedly,
+// until it returns false, which ends the stream, or error. The element returned with false is not emitted.
+// Stream can be infinite, when it is used with a step which stops it, like Take.
+// If gen returns error or context is cancelled during processing, Stream stops processing and returns error.
+func Generate[I any](ctx context.Context, gen func(context.Context) (I, bool, error), ops ...Option[I]) Stream[I] {
+	results := newOutput(ctx, ops)
+	stopper := newStopper()
+
+	eg, ctx := errgroup.WithContext(sourceContext(ctx, ops))
+	goStep(eg, ctx, "Generate", applyOptions(ops), func() error {
+		defer close(results)
+
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			elem, more, err := gen(ctx)
+			if err != nil || !more {
+				return err
+			}
+
+			if err := emit(ctx, stopper, results, elem); err != nil {
+				return err
+			}
+		}
+	})
+
+	return Stream[I]{
+		in:      results,
+		eg:      eg,
+		ctx:     ctx,
+		stopper: stopper,
 	}
 }
 
+// Repeat creates a new infinite Stream emitting value, until it is stopped by a step like Take
+// or context is cancelled. If context is cancelled, Stream stops processing and returns error.
+func Repeat[I any](ctx context.Context, value I, ops ...Option[I]) Stream[I] {
+	return FromIter(ctx, func(yield func(I) bool) error {
+		for {
+			if !yield(value) {
+				return nil
+			}
+		}
+	}, ops...)
+}
+
+// RepeatN creates a new Stream emitting value n times.
+// If context is cancelled during processing, Stream stops processing and returns error.
+func RepeatN[I any](ctx context.Context, value I, n int, ops ...Option[I]) Stream[I] {
+	return FromIter(ctx, func(yield func(I) bool) error {
+		for i := 0; i < n; i++ {
+			if !yield(value) {
+				break
+			}
+		}
+
+		return nil
+	}, ops...)
+}
+
+// Range creates a new Stream of integers from start (inclusive) to stop (exclusive), incremented by step.
+// A negative step counts down, from start to stop, which is less than start then.
+// If step is zero, Stream does not produce any element and returns ErrZeroStep.
+// If context is cancelled during processing, Stream stops processing and returns error.
+func Range(ctx context.Context, start, stop, step int, ops ...Option[int]) Stream[int] {
+	return FromIter(ctx, func(yield func(int) bool) error {
+		if step == 0 {
+			return ErrZeroStep
+		}
+
+		for i := start; (step > 0 && i < stop) || (step < 0 && i > stop); i += step {
+			if !yield(i) {
+				break
+			}
+		}
+
+		return nil
+	}, ops...)
+}
+
 // Map transforms Stream into a Stream of another type.
 // If error occurs or context is cancelled during processing, Map stops processing and returns error.
 func Map[I any, O any](pipe Stream[I], mapper func(context.Context, I) (O, error), ops ...Option[O]) Stream[O] {
-	output := make(chan O)
-	for _, op := range ops {
-		output = op()
-	}
+	opts := applyOptions(ops)
+	output := make(chan O, opts.buffer(pipe.ctx))
+	stopper := newStopper()
 
-	pipe.eg.Go(func() error {
+	goStep(pipe.eg, pipe.ctx, "Map", opts, func() error {
 		defer close(output)
+		defer pipe.stop()
 
 		for elem := range pipe.in {
-			mapped, err := mapper(pipe.ctx, elem)
+			var mapped O
+			err := invoke(pipe.ctx, opts, func(ctx context.Context) (err error) {
+				mapped, err = mapper(ctx, elem)
+
+				return
+			})
 			if err != nil {
 				return err
 			}
 
-			if err := push(pipe.ctx, output, mapped); err != nil {
+			if err := emit(pipe.ctx, stopper, output, mapped); err != nil {
 				return err
 			}
 		}
@@ -123,12 +296,109 @@ func Map[I any, O any](pipe Stream[I], mapper func(context.Context, I) (O, error
 	})
 
 	return Stream[O]{
-		in:  output,
-		eg:  pipe.eg,
-		ctx: pipe.ctx,
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// MapReduceEmit transforms each element like Map, while folding elements into a state,
+// and emits one more element, produced by summary from the final state, after the stream ends.
+// For example, it can pass rows through and append a totals row at the end.
+// If transform returns error or context is cancelled during processing, MapReduceEmit stops processing and returns error.
+func MapReduceEmit[I, O, S any](pipe Stream[I], transform func(context.Context, I) (O, error), fold func(S, I) S, summary func(S) O, initial S, ops ...Option[O]) Stream[O] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, "MapReduceEmit", applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		state := initial
+		for elem := range pipe.in {
+			mapped, err := transform(pipe.ctx, elem)
+			if err != nil {
+				return err
+			}
+			state = fold(state, elem)
+
+			if err := emit(pipe.ctx, stopper, output, mapped); err != nil {
+				return err
+			}
+		}
+
+		return emit(pipe.ctx, stopper, output, summary(state))
+	})
+
+	return Stream[O]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
 	}
 }
 
+// MapFallback is like Map, but if primary mapper returns error, it tries fallback mapper with the same element.
+// If both mappers fail, MapFallback stops processing and returns error of the fallback.
+// If context is cancelled during processing, MapFallback stops processing without calling fallback and returns error.
+func MapFallback[I any, O any](pipe Stream[I], primary, fallback func(context.Context, I) (O, error), ops ...Option[O]) Stream[O] {
+	return Map[I, O](
+		pipe,
+		func(ctx context.Context, elem I) (O, error) {
+			mapped, err := primary(ctx, elem)
+			if err == nil {
+				return mapped, nil
+			}
+			if ctx.Err() != nil {
+				return mapped, ctx.Err()
+			}
+
+			return fallback(ctx, elem)
+		},
+		ops...,
+	)
+}
+
+// Recover is like Map, but if mapper returns error, handler decides what to do with the element:
+// it returns a fallback value and true to emit it, false to skip the element, or error to stop processing.
+// If context is cancelled during processing, Recover stops processing without calling handler and returns error.
+func Recover[I any, O any](
+	pipe Stream[I],
+	mapper func(context.Context, I) (O, error),
+	handler func(context.Context, I, error) (O, bool, error),
+	ops ...Option[O],
+) Stream[O] {
+	return FilterMap[I, O](
+		pipe,
+		func(ctx context.Context, elem I) (O, bool, error) {
+			mapped, err := mapper(ctx, elem)
+			if err == nil {
+				return mapped, true, nil
+			}
+			if ctx.Err() != nil {
+				return mapped, false, ctx.Err()
+			}
+
+			return handler(ctx, elem, err)
+		},
+		ops...,
+	)
+}
+
+// Peek calls fn for each element and passes the element through unchanged.
+// It is useful for side effects, like logging or metrics.
+// If fn returns error or context is cancelled during processing, Peek stops processing and returns error.
+func Peek[I any](pipe Stream[I], fn func(context.Context, I) error, ops ...Option[I]) Stream[I] {
+	return Map[I, I](
+		pipe,
+		func(ctx context.Context, elem I) (I, error) {
+			return elem, fn(ctx, elem)
+		},
+		ops...,
+	)
+}
+
 // Filter returns a Stream which obtained after filtering using given callback function.
 // The callback function should return  whether the element should be included or not.
 // If error occurs or context is cancelled during processing, Filter stops processing and returns error.
@@ -148,24 +418,36 @@ func Filter[I any](pipe Stream[I], callback func(context.Context, I) (bool, erro
 // The callback function should return result of the mapping operation and whether the element should be included or not.
 // If error occurs or context is cancelled during processing, FilterMap stops processing and returns error.
 func FilterMap[I any, O any](pipe Stream[I], callback func(context.Context, I) (O, bool, error), ops ...Option[O]) Stream[O] {
-	output := make(chan O)
-	for _, op := range ops {
-		output = op()
-	}
+	opts := applyOptions(ops)
+	output := make(chan O, opts.buffer(pipe.ctx))
+	stopper := newStopper()
 
-	pipe.eg.Go(func() error {
+	goStep(pipe.eg, pipe.ctx, "FilterMap", opts, func() error {
 		defer close(output)
+		defer pipe.stop()
 
 		for elem := range pipe.in {
-			mapped, ok, err := callback(pipe.ctx, elem)
+			var (
+				mapped O
+				ok     bool
+			)
+			err := invoke(pipe.ctx, opts, func(ctx context.Context) (err error) {
+				mapped, ok, err = callback(ctx, elem)
+
+				return
+			})
 			if err != nil {
 				return err
 			}
 			if !ok {
+				if err := dropped(stopper); err != nil {
+					return err
+				}
+
 				continue
 			}
 
-			if err := push(pipe.ctx, output, mapped); err != nil {
+			if err := emit(pipe.ctx, stopper, output, mapped); err != nil {
 				return err
 			}
 		}
@@ -174,118 +456,312 @@ func FilterMap[I any, O any](pipe Stream[I], callback func(context.Context, I) (
 	})
 
 	return Stream[O]{
-		in:  output,
-		eg:  pipe.eg,
-		ctx: pipe.ctx,
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
 	}
 }
 
 // Batch converts a steam of elements into a steam of slices of elements of given size.
+// Slices can be reused with WithBatchPool.
 // If context is cancelled during processing, Batch stops processing and returns error.
 func Batch[I any](pipe Stream[I], size int, ops ...Option[[]I]) Stream[[]I] {
-	output := make(chan []I)
-	for _, op := range ops {
-		output = op()
-	}
+	opts := applyOptions(ops, batchPoolSetting)
+	output := make(chan []I, opts.buffer(pipe.ctx))
+	stopper := newStopper()
 
-	pipe.eg.Go(func() error {
+	goStep(pipe.eg, pipe.ctx, "Batch", opts, func() error {
 		defer close(output)
+		defer pipe.stop()
 
-		batch := make([]I, 0, size)
+		batch := newBatch[I](opts, size)
 		for elem := range pipe.in {
 			batch = append(batch, elem)
 			if len(batch) == size {
-				if err := push(pipe.ctx, output, batch); err != nil {
+				if err := emit(pipe.ctx, stopper, output, batch); err != nil {
+					return err
+				}
+
+				batch = newBatch[I](opts, size)
+			}
+		}
+
+		if len(batch) > 0 {
+			return emit(pipe.ctx, stopper, output, batch)
+		}
+
+		return nil
+	})
+
+	return Stream[[]I]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// BatchWeighted converts a steam of elements into a steam of slices of elements with total weight
+// of at most maxWeight, e.g. to bound the size of uploaded chunks. It collects elements into slice
+// until adding the next element would exceed maxWeight, and sends them as a batch.
+// An element heavier than maxWeight is sent as a batch of its own. The last batch is sent when the stream ends.
+// Slices can be reused with WithBatchPool.
+// If context is cancelled during processing, BatchWeighted stops processing and returns error.
+func BatchWeighted[I any](pipe Stream[I], maxWeight int, weight func(I) int, ops ...Option[[]I]) Stream[[]I] {
+	opts := applyOptions(ops, batchPoolSetting)
+	output := make(chan []I, opts.buffer(pipe.ctx))
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, "BatchWeighted", opts, func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		batch := newBatch[I](opts, 0)
+		total := 0
+		for elem := range pipe.in {
+			w := weight(elem)
+			if len(batch) > 0 && total+w > maxWeight {
+				if err := emit(pipe.ctx, stopper, output, batch); err != nil {
 					return err
 				}
 
-				batch = make([]I, 0, size)
+				batch = newBatch[I](opts, 0)
+				total = 0
 			}
+
+			batch = append(batch, elem)
+			total += w
 		}
 
 		if len(batch) > 0 {
-			return push(pipe.ctx, output, batch)
+			return emit(pipe.ctx, stopper, output, batch)
 		}
 
 		return nil
 	})
 
 	return Stream[[]I]{
-		in:  output,
-		eg:  pipe.eg,
-		ctx: pipe.ctx,
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
 	}
 }
 
 // BatchTimeout converts a steam of elements into a steam of slices of elements.
-// It collects elements into slice until it reaches maximum size or until timeout occurs, and sends them as a batch.
+// It collects elements into slice until it reaches maximum size or until timeout elapses
+// since the first element of the slice, and sends them as a batch. The last batch is sent when the stream ends.
+// Slices can be reused with WithBatchPool.
 // If context is cancelled during processing, BatchTimeout stops processing and returns error.
 func BatchTimeout[I any](pipe Stream[I], size int, timeout time.Duration, ops ...Option[[]I]) Stream[[]I] {
-	output := make(chan []I)
-	for _, op := range ops {
-		output = op()
-	}
-	ticker := time.NewTicker(timeout)
+	opts := applyOptions(ops, batchPoolSetting)
+	output := make(chan []I, opts.buffer(pipe.ctx))
+	stopper := newStopper()
 
-	pipe.eg.Go(func() error {
+	goStep(pipe.eg, pipe.ctx, "BatchTimeout", opts, func() error {
 		defer close(output)
-		defer ticker.Stop()
+		defer pipe.stop()
+
+		var (
+			timer  *time.Timer
+			timerC <-chan time.Time
+		)
+		defer func() { stopTimer(timer) }()
+
+		batch := newBatch[I](opts, size)
+		flush := func() error {
+			stopTimer(timer)
+			timer, timerC = nil, nil
+
+			if err := emit(pipe.ctx, stopper, output, batch); err != nil {
+				return err
+			}
+			batch = newBatch[I](opts, size)
+
+			return nil
+		}
 
-		batch := make([]I, 0, size)
-	loop:
 		for {
 			select {
-			case d, ok := <-pipe.in:
+			case <-pipe.ctx.Done():
+				return pipe.ctx.Err()
+			case elem, ok := <-pipe.in:
 				if !ok {
-					break loop
+					if len(batch) > 0 {
+						return flush()
+					}
+
+					return nil
 				}
 
-				batch = append(batch, d)
-				if len(batch) == size {
-					if err := push(pipe.ctx, output, batch); err != nil {
+				batch = append(batch, elem)
+				if len(batch) == 1 {
+					timer = time.NewTimer(timeout)
+					timerC = timer.C
+				}
+				if len(batch) >= size {
+					if err := flush(); err != nil {
 						return err
 					}
-					batch = make([]I, 0, size)
 				}
-			case <-ticker.C:
-				if len(batch) == 0 {
+			case <-timerC:
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+	})
+
+	return Stream[[]I]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// EnforceMonotonic reorders slightly disordered stream, so elements are emitted in order defined by less.
+// It holds up to maxBuffer elements in a buffer and emits the smallest one when the buffer is full,
+// so an element can be moved back by at most maxBuffer positions.
+// If an element arrives too late, when a greater element is already emitted,
+// EnforceMonotonic stops processing and returns ErrOutOfOrder. Use EnforceMonotonicDrop to drop such elements instead.
+// If context is cancelled during processing, EnforceMonotonic stops processing and returns error.
+func EnforceMonotonic[I any](pipe Stream[I], less func(I, I) bool, maxBuffer int, ops ...Option[I]) Stream[I] {
+	return enforceMonotonic(pipe, "EnforceMonotonic", less, maxBuffer, false, ops...)
+}
+
+// EnforceMonotonicDrop is like EnforceMonotonic, but it drops elements which arrive too late.
+func EnforceMonotonicDrop[I any](pipe Stream[I], less func(I, I) bool, maxBuffer int, ops ...Option[I]) Stream[I] {
+	return enforceMonotonic(pipe, "EnforceMonotonicDrop", less, maxBuffer, true, ops...)
+}
+
+func enforceMonotonic[I any](pipe Stream[I], name string, less func(I, I) bool, maxBuffer int, drop bool, ops ...Option[I]) Stream[I] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, name, applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		buffer := newLessHeap(less)
+		var (
+			last    I
+			emitted bool
+		)
+		for elem := range pipe.in {
+			if emitted && less(elem, last) {
+				if drop {
+					if err := dropped(stopper); err != nil {
+						return err
+					}
+
 					continue
 				}
-				if err := push(pipe.ctx, output, batch); err != nil {
+
+				return ErrOutOfOrder
+			}
+
+			buffer.push(elem)
+			if buffer.Len() > maxBuffer {
+				last, emitted = buffer.pop(), true
+				if err := emit(pipe.ctx, stopper, output, last); err != nil {
 					return err
 				}
-				batch = make([]I, 0, size)
 			}
 		}
 
-		if len(batch) > 0 {
-			return push(pipe.ctx, output, batch)
+		for buffer.Len() > 0 {
+			if err := emit(pipe.ctx, stopper, output, buffer.pop()); err != nil {
+				return err
+			}
 		}
 
 		return nil
 	})
 
-	return Stream[[]I]{
-		in:  output,
-		eg:  pipe.eg,
-		ctx: pipe.ctx,
+	return Stream[I]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// Store keeps keys of already processed elements, e.g. in Redis or SQL database.
+type Store interface {
+	// Seen reports whether the key was marked before.
+	Seen(ctx context.Context, key string) (bool, error)
+	// Mark marks the key as processed.
+	Mark(ctx context.Context, key string) error
+}
+
+// DedupePersistent skips elements which keys, returned by offset, are already marked in the store,
+// e.g. by a previous run. Keys of emitted elements are marked after the next step receives them.
+// This gives exactly-once processing for sources, which may deliver the same element more than once.
+// If store returns error or context is cancelled during processing, DedupePersistent stops processing and returns error.
+func DedupePersistent[I any](pipe Stream[I], offset func(I) string, store Store, ops ...Option[I]) Stream[I] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, "DedupePersistent", applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		for elem := range pipe.in {
+			key := offset(elem)
+			seen, err := store.Seen(pipe.ctx, key)
+			if err != nil {
+				return fmt.Errorf("check key %q: %w", key, err)
+			}
+			if seen {
+				if err := dropped(stopper); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			if err := emit(pipe.ctx, stopper, output, elem); err != nil {
+				return err
+			}
+
+			if err := store.Mark(pipe.ctx, key); err != nil {
+				return fmt.Errorf("mark key %q: %w", key, err)
+			}
+		}
+
+		return nil
+	})
+
+	return Stream[I]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
 	}
 }
 
 // UnBatch converts a stream of slices of elements into a stream of elements.
 // If context is cancelled during processing, UnBatch stops processing and returns error.
 func UnBatch[I any](pipe Stream[[]I], ops ...Option[I]) Stream[I] {
-	output := make(chan I)
-	for _, op := range ops {
-		output = op()
-	}
+	return Flatten[[]I](pipe, ops...)
+}
 
-	pipe.eg.Go(func() error {
+// Flatten is like UnBatch, but for a stream of any slice type, like a named slice type.
+// It emits elements of each slice one by one in order. Arrays can be flattened after converting them into slices.
+// If context is cancelled during processing, Flatten stops processing and returns error.
+func Flatten[S ~[]E, E any](pipe Stream[S], ops ...Option[E]) Stream[E] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, "Flatten", applyOptions(ops), func() error {
 		defer close(output)
+		defer pipe.stop()
 
 		for batch := range pipe.in {
 			for _, elem := range batch {
-				if err := push(pipe.ctx, output, elem); err != nil {
+				if err := emit(pipe.ctx, stopper, output, elem); err != nil {
 					return err
 				}
 			}
@@ -294,23 +770,31 @@ func UnBatch[I any](pipe Stream[[]I], ops ...Option[I]) Stream[I] {
 		return nil
 	})
 
-	return Stream[I]{
-		in:  output,
-		eg:  pipe.eg,
-		ctx: pipe.ctx,
+	return Stream[E]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
 	}
 }
 
-// ForEach processes each element in the stream using the given callback function.
-// If callback returns error or context is cancelled during processing, ForEach stops and returns error.
-func ForEach[I any](pipe Stream[I], callback func(context.Context, I) error) error {
-	pipe.eg.Go(func() error {
-		for elem := range pipe.in {
-			if pipe.ctx.Err() != nil {
-				return pipe.ctx.Err()
-			}
+// Buffer passes elements through a separate step, which reads up to size elements ahead of the next step,
+// so a slow next step does not stall the previous ones, until the buffer is full.
+// Unlike WithBuffer, it can be added to an already built Stream.
+// If context is cancelled during processing, Buffer stops processing and returns error.
+func Buffer[I any](pipe Stream[I], size int) Stream[I] {
+	if size < 0 {
+		size = 0
+	}
+	output := make(chan I, size)
+	stopper := newStopper()
 
-			if err := callback(pipe.ctx, elem); err != nil {
+	goStep(pipe.eg, pipe.ctx, "Buffer", Options[I]{}, func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		for elem := range pipe.in {
+			if err := emit(pipe.ctx, stopper, output, elem); err != nil {
 				return err
 			}
 		}
@@ -318,36 +802,1319 @@ func ForEach[I any](pipe Stream[I], callback func(context.Context, I) error) err
 		return nil
 	})
 
-	return pipe.eg.Wait()
+	return Stream[I]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
 }
 
-// Reduce reduces a stream to a value which is the accumulated result of running each element in collection
-// through accumulator, where each successive invocation is supplied the return value of the previous.
-// If accum returns error or context is cancelled during processing, Reduce stops and returns error.
-//
-//nolint:ireturn // ireturn suggests to return `any`, but we need to return specific type
-func Reduce[I any, R any](pipe Stream[I], accum func(R, I) (R, error), initial R) (R, error) {
-	fn := func(ctx context.Context, elem I) (err error) {
-		initial, err = accum(initial, elem) // a little bit of magical, but it works
-
-		return
+// BufferDropOldest is like Buffer, but it never blocks the previous steps: when the buffer of size elements is full,
+// it drops the oldest element to make room for the new one. It intentionally loses elements under load,
+// for cases where fresh elements are more important than all of them, like live dashboards.
+// Dropped elements are reported to the observer set with WithObserver, if it implements DropObserver.
+// Size less than 1 is treated as 1. The buffer of size elements is the only buffer of the step,
+// its output is unbuffered regardless of WithBuffer and WithDefaultBuffer, so it never holds more stale elements.
+// If context is cancelled during processing, BufferDropOldest stops processing and returns error.
+func BufferDropOldest[I any](pipe Stream[I], size int, ops ...Option[I]) Stream[I] {
+	if size < 1 {
+		size = 1
 	}
+	opts := applyOptions(ops)
+	dropObs, _ := opts.Observer.(DropObserver)
+	output := make(chan I)
+	stopper := newStopper()
 
-	err := ForEach(pipe, fn)
+	goStep(pipe.eg, pipe.ctx, "BufferDropOldest", opts, func() error {
+		defer close(output)
+		defer pipe.stop()
 
-	return initial, err
-}
+		input := pipe.in
+		queue := make([]I, 0, size)
+		for input != nil || len(queue) > 0 {
+			var (
+				next chan<- I // nil, so never ready, if there is nothing to send
+				head I
+			)
+			if len(queue) > 0 {
+				next, head = output, queue[0]
+			}
 
-// Collect collects all elements from the stream into a slice.
-// If context is cancelled during processing, Collect stops and returns error.
-func Collect[I any](p Stream[I]) ([]I, error) {
-	return Reduce(
-		p,
+			select {
+			case <-pipe.ctx.Done():
+				return pipe.ctx.Err()
+			case <-stopper.stopped():
+				return errStopped
+			case elem, ok := <-input:
+				if !ok {
+					input = nil
+
+					continue
+				}
+
+				if len(queue) == size {
+					queue = queue[1:]
+					if dropObs != nil {
+						dropObs.OnDrop()
+					}
+				}
+				queue = append(queue, elem)
+			case next <- head:
+				queue = queue[1:]
+			}
+		}
+
+		return nil
+	})
+
+	return Stream[I]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// FlatMap transforms each element of the stream into a slice of elements and emits them one by one in order.
+// An empty slice emits nothing for the element.
+// If mapper returns error or context is cancelled during processing, FlatMap stops processing and returns error.
+func FlatMap[I any, O any](pipe Stream[I], mapper func(context.Context, I) ([]O, error), ops ...Option[O]) Stream[O] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, "FlatMap", applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		for elem := range pipe.in {
+			mapped, err := mapper(pipe.ctx, elem)
+			if err != nil {
+				return err
+			}
+
+			for _, m := range mapped {
+				if err := emit(pipe.ctx, stopper, output, m); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return Stream[O]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// Scan is like Reduce, but emits the accumulated value after each element, like a running total.
+// The first emitted value is the result of accum with initial and the first element.
+// If accum returns error or context is cancelled during processing, Scan stops processing and returns error.
+func Scan[I any, R any](pipe Stream[I], accum func(R, I) (R, error), initial R, ops ...Option[R]) Stream[R] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, "Scan", applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		acc := initial
+		for elem := range pipe.in {
+			var err error
+			if acc, err = accum(acc, elem); err != nil {
+				return err
+			}
+
+			if err := emit(pipe.ctx, stopper, output, acc); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return Stream[R]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// Group is a group of elements with the same key.
+type Group[K comparable, I any] struct {
+	// Key is the key of the group.
+	Key K
+	// Items are the elements of the group in order of arrival.
+	Items []I
+}
+
+// GroupBy groups elements of the stream by key and emits the groups when the input ends,
+// in order in which their keys were first seen. All elements are held in memory until the input ends,
+// so it is not suitable for infinite streams.
+// If key returns error or context is cancelled during processing, GroupBy stops processing and returns error.
+func GroupBy[I any, K comparable](pipe Stream[I], key func(context.Context, I) (K, error), ops ...Option[Group[K, I]]) Stream[Group[K, I]] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, "GroupBy", applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		var keys []K
+		groups := make(map[K][]I)
+		for elem := range pipe.in {
+			k, err := key(pipe.ctx, elem)
+			if err != nil {
+				return err
+			}
+
+			if _, ok := groups[k]; !ok {
+				keys = append(keys, k)
+			}
+			groups[k] = append(groups[k], elem)
+
+			if err := dropped(stopper); err != nil {
+				return err
+			}
+		}
+
+		if err := pipe.ctx.Err(); err != nil {
+			return err // input is incomplete
+		}
+
+		for _, k := range keys {
+			if err := emit(pipe.ctx, stopper, output, Group[K, I]{Key: k, Items: groups[k]}); err != nil {
+				return err
+			}
+			delete(groups, k)
+		}
+
+		return nil
+	})
+
+	return Stream[Group[K, I]]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// Sort emits all elements of the stream sorted according to less, when the input ends.
+// Sorting is stable, so equal elements keep the order of arrival. All elements are held in memory
+// until the input ends and nothing is emitted before that, so it is not suitable for infinite streams.
+// For the smallest or greatest elements only, use TopN, which holds only them.
+// If context is cancelled during processing, Sort stops processing and returns error.
+func Sort[I any](pipe Stream[I], less func(I, I) bool, ops ...Option[I]) Stream[I] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, "Sort", applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		var elems []I
+		for elem := range pipe.in {
+			elems = append(elems, elem)
+
+			if err := dropped(stopper); err != nil {
+				return err
+			}
+		}
+
+		if err := pipe.ctx.Err(); err != nil {
+			return err // input is incomplete
+		}
+
+		sort.SliceStable(elems, func(i, j int) bool { return less(elems[i], elems[j]) })
+		for _, elem := range elems {
+			if err := emit(pipe.ctx, stopper, output, elem); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return Stream[I]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// TopN emits the n greatest elements of the stream according to less, from the greatest one, when the input ends.
+// For the n smallest elements, reverse less. Only n elements are held in memory, whatever the length of the stream,
+// but nothing is emitted before the input ends. The order of equal elements is not defined.
+// If n is not positive, TopN emits nothing and stops the input.
+// If context is cancelled during processing, TopN stops processing and returns error.
+func TopN[I any](pipe Stream[I], n int, less func(I, I) bool, ops ...Option[I]) Stream[I] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, "TopN", applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		if n <= 0 {
+			return nil
+		}
+
+		top := newLessHeap(less) // the smallest of the top elements is first
+		for elem := range pipe.in {
+			switch {
+			case top.Len() < n:
+				top.push(elem)
+			case less(top.peek(), elem):
+				top.replace(elem)
+			}
+
+			if err := dropped(stopper); err != nil {
+				return err
+			}
+		}
+
+		if err := pipe.ctx.Err(); err != nil {
+			return err // input is incomplete
+		}
+
+		elems := make([]I, top.Len())
+		for i := len(elems) - 1; i >= 0; i-- {
+			elems[i] = top.pop()
+		}
+		for _, elem := range elems {
+			if err := emit(pipe.ctx, stopper, output, elem); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return Stream[I]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// ChunkBy groups consecutive elements with the same key into slices, starting a new slice when the key changes.
+// Unlike GroupBy, it emits each slice as soon as the next key arrives and holds only the current slice in memory,
+// so elements with the same key, which are not consecutive, are emitted in different slices.
+// The last slice is emitted when the stream ends.
+// If context is cancelled during processing, ChunkBy stops processing and returns error.
+func ChunkBy[I any, K comparable](pipe Stream[I], key func(I) K, ops ...Option[[]I]) Stream[[]I] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, "ChunkBy", applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		var (
+			chunk   []I
+			current K
+		)
+		for elem := range pipe.in {
+			k := key(elem)
+			if len(chunk) > 0 && k != current {
+				if err := emit(pipe.ctx, stopper, output, chunk); err != nil {
+					return err
+				}
+				chunk = nil
+			}
+
+			current = k
+			chunk = append(chunk, elem)
+		}
+
+		if len(chunk) > 0 {
+			return emit(pipe.ctx, stopper, output, chunk)
+		}
+
+		return nil
+	})
+
+	return Stream[[]I]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// Dedup drops consecutive equal elements, keeping the first element of each run, like uniq.
+// It remembers only the last element, so equal elements, which are not consecutive, are all emitted.
+// If context is cancelled during processing, Dedup stops processing and returns error.
+func Dedup[I comparable](pipe Stream[I], ops ...Option[I]) Stream[I] {
+	return DedupBy(pipe, func(elem I) I { return elem }, ops...)
+}
+
+// DedupBy is like Dedup, but elements are equal when they have the same key.
+func DedupBy[I any, K comparable](pipe Stream[I], key func(I) K, ops ...Option[I]) Stream[I] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, "DedupBy", applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		var (
+			last    K
+			emitted bool
+		)
+		for elem := range pipe.in {
+			k := key(elem)
+			if emitted && k == last {
+				if err := dropped(stopper); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			if err := emit(pipe.ctx, stopper, output, elem); err != nil {
+				return err
+			}
+			last, emitted = k, true
+		}
+
+		return nil
+	})
+
+	return Stream[I]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// DistinctWindow drops elements equal to one of the last window distinct elements.
+// Each element refreshes the memory of its value, like the least recently used cache,
+// and the least recently seen value is forgotten when there are more than window values.
+// So memory is bounded, but the deduplication is approximate: an element equal to a forgotten one is emitted again.
+// Window less than 1 is treated as 1.
+// If context is cancelled during processing, DistinctWindow stops processing and returns error.
+func DistinctWindow[I comparable](pipe Stream[I], window int, ops ...Option[I]) Stream[I] {
+	if window < 1 {
+		window = 1
+	}
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, "DistinctWindow", applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		recent := list.New() // most recently seen values first
+		seen := make(map[I]*list.Element, window)
+		for elem := range pipe.in {
+			if e, ok := seen[elem]; ok {
+				recent.MoveToFront(e)
+				if err := dropped(stopper); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			seen[elem] = recent.PushFront(elem)
+			if recent.Len() > window {
+				delete(seen, recent.Remove(recent.Back()).(I))
+			}
+
+			if err := emit(pipe.ctx, stopper, output, elem); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return Stream[I]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// Take emits at most n elements of the stream and then ends it.
+// After that the preceding steps are stopped: each of them ends without error when it emits its next element,
+// so a source created with FromIter is stopped when yield returns false.
+// If n is not positive, the stream is empty and no elements are pulled.
+// If context is cancelled during processing, Take stops processing and returns error.
+func Take[I any](pipe Stream[I], n int, ops ...Option[I]) Stream[I] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, "Take", applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		for taken := 0; taken < n; taken++ {
+			elem, ok, err := pull(pipe.ctx, pipe.in)
+			if err != nil || !ok {
+				return err
+			}
+
+			if err := emit(pipe.ctx, stopper, output, elem); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return Stream[I]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// TakeWhile emits elements while pred returns true and ends the stream at the first element for which it returns false.
+// The preceding steps are stopped then, same as with Take.
+// If pred returns error or context is cancelled during processing, TakeWhile stops processing and returns error.
+func TakeWhile[I any](pipe Stream[I], pred func(context.Context, I) (bool, error), ops ...Option[I]) Stream[I] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, "TakeWhile", applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		for elem := range pipe.in {
+			ok, err := pred(pipe.ctx, elem)
+			if err != nil || !ok {
+				return err
+			}
+
+			if err := emit(pipe.ctx, stopper, output, elem); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return Stream[I]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// Skip drops the first n elements of the stream and emits the rest.
+// If the stream has n or fewer elements, the result is empty.
+// If context is cancelled during processing, Skip stops processing and returns error.
+func Skip[I any](pipe Stream[I], n int, ops ...Option[I]) Stream[I] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, "Skip", applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		for skipped := 0; skipped < n; skipped++ {
+			if _, ok, err := pull(pipe.ctx, pipe.in); err != nil || !ok {
+				return err
+			}
+		}
+
+		for elem := range pipe.in {
+			if err := emit(pipe.ctx, stopper, output, elem); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return Stream[I]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// Sample emits every n-th element, the first, n+1-th, 2n+1-th and so on, and drops the rest.
+// If n is not positive, Sample stops processing and returns ErrInvalidSample.
+// If context is cancelled during processing, Sample stops processing and returns error.
+func Sample[I any](pipe Stream[I], n int, ops ...Option[I]) Stream[I] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, "Sample", applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		if n <= 0 {
+			return ErrInvalidSample
+		}
+
+		i := 0
+		for elem := range pipe.in {
+			keep := i == 0
+			i = (i + 1) % n
+			if !keep {
+				if err := dropped(stopper); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			if err := emit(pipe.ctx, stopper, output, elem); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return Stream[I]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// SkipWhile drops elements while pred returns true. Starting from the first element for which pred returns false,
+// it emits all elements without calling pred.
+// If pred returns error or context is cancelled during processing, SkipWhile stops processing and returns error.
+func SkipWhile[I any](pipe Stream[I], pred func(context.Context, I) (bool, error), ops ...Option[I]) Stream[I] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, "SkipWhile", applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		skipping := true
+		for {
+			elem, ok, err := pull(pipe.ctx, pipe.in)
+			if err != nil || !ok {
+				return err
+			}
+
+			if skipping {
+				skip, err := pred(pipe.ctx, elem)
+				if err != nil {
+					return err
+				}
+				if skip {
+					if err := dropped(stopper); err != nil {
+						return err
+					}
+
+					continue
+				}
+				skipping = false
+			}
+
+			if err := emit(pipe.ctx, stopper, output, elem); err != nil {
+				return err
+			}
+		}
+	})
+
+	return Stream[I]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// MapError passes elements through, but transforms the error of the preceding steps with transform,
+// before it is returned from the terminal, e.g. to annotate which part of the pipeline failed.
+// Transform should wrap the error with %w, so errors.Is and errors.As still work.
+// Errors caused by the context cancellation, and errors of the next steps are not transformed.
+// If context is cancelled during processing, MapError stops processing and returns error.
+func MapError[I any](pipe Stream[I], transform func(error) error, ops ...Option[I]) Stream[I] {
+	return handleError(pipe, "MapError", transform, ops)
+}
+
+// Catch passes elements through, but calls handler with the error of the preceding steps, when they fail.
+// If handler returns nil, the error is handled: the stream ends normally after the elements received before the error.
+// Otherwise the error returned by handler is returned from the terminal, so handler can check the error,
+// e.g. with errors.As, and return it back, if it is not known to be recoverable.
+// Errors caused by the context cancellation are never handled, and errors of the next steps are not passed to handler.
+// If context is cancelled during processing, Catch stops processing and returns error.
+func Catch[I any](pipe Stream[I], handler func(error) error, ops ...Option[I]) Stream[I] {
+	return handleError(pipe, "Catch", handler, ops)
+}
+
+// OnComplete passes elements through and calls fn once, when all the preceding steps end,
+// e.g. to close resources used by a custom source. fn receives the error of the preceding steps,
+// or nil if they succeeded or were stopped by the next steps, like First or Take, which need no more elements.
+// If the next steps fail, the preceding steps are cancelled, and fn receives context.Canceled.
+// Terminals return after fn returns, as it runs within the pipeline.
+// If context is cancelled during processing, OnComplete stops processing and returns error.
+func OnComplete[I any](pipe Stream[I], fn func(error), ops ...Option[I]) Stream[I] {
+	return watch(pipe, "OnComplete", func(err error) error {
+		_, cause := unmark(err)
+		fn(cause)
+
+		return err
+	}, ops)
+}
+
+// handleError replaces the error of the preceding steps with the error returned by handler, nil ends the stream normally.
+func handleError[I any](pipe Stream[I], name string, handler func(error) error, ops []Option[I]) Stream[I] {
+	return watch(pipe, name, func(err error) error {
+		if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+
+		failed, err := unmark(err)
+		if err = handler(err); err != nil && failed != "" {
+			err = &stepError{step: failed, err: err} // keeps the name of the failed step
+		}
+
+		return err
+	}, ops)
+}
+
+// watch passes elements of pipe through within a new group, and calls done with the error of pipe,
+// when all its steps end. The error of a pipe stopped by the next steps is nil, or the cancellation error,
+// if they failed. The error returned by done fails the group, nil ends the stream normally.
+func watch[I any](pipe Stream[I], name string, done func(error) error, ops []Option[I]) Stream[I] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	eg, ctx := errgroup.WithContext(detachedContext{Context: pipe.ctx})
+	attachCtx, detach := context.WithCancel(ctx)
+	input := relay(attachCtx, pipe)
+	result := make(chan error, 1)
+	eg.Go(func() error {
+		err := pipe.eg.Wait()
+		if errors.Is(err, errDetached) {
+			err = ctx.Err()
+		}
+		err = done(err)
+		result <- err
+
+		return err
+	})
+
+	goStep(eg, ctx, name, applyOptions(ops), func() error {
+		defer close(output)
+		defer detach() // stops the preceding steps, if the stream is stopped
+
+		for elem := range input {
+			if err := emit(ctx, stopper, output, elem); err != nil {
+				return err
+			}
+		}
+
+		if <-result != nil { // the preceding steps failed, wait until the error reaches the group
+			<-ctx.Done()
+
+			return ctx.Err()
+		}
+
+		return nil
+	})
+
+	return Stream[I]{
+		in:      output,
+		eg:      eg,
+		ctx:     ctx,
+		stopper: stopper,
+	}
+}
+
+// WithCancel returns a copy of the stream with a new context, which is cancelled when the returned cancel function is called.
+// Calling cancel stops the whole pipeline, including the steps before WithCancel,
+// and terminal returns context.Canceled.
+func WithCancel[I any](pipe Stream[I]) (Stream[I], context.CancelFunc) {
+	output := make(chan I)
+	stopper := newStopper()
+	ctx, cancel := context.WithCancel(pipe.ctx)
+
+	goStep(pipe.eg, pipe.ctx, "WithCancel", Options[I]{}, func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err() // fails the group, so preceding steps are stopped too
+			case elem, ok := <-pipe.in:
+				if !ok {
+					return nil
+				}
+
+				if err := emit(ctx, stopper, output, elem); err != nil {
+					return err
+				}
+			}
+		}
+	})
+
+	return Stream[I]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     ctx,
+		stopper: stopper,
+	}, cancel
+}
+
+// WithContext returns a copy of the stream, whose subsequent steps use ctx instead of the pipeline context,
+// e.g. to set a deadline or a value for them only. ctx should be derived from the pipeline context,
+// like context.WithTimeout(pipe.Context(), d). When ctx is done, the whole pipeline is stopped,
+// including the steps before WithContext, and terminal returns the error of ctx.
+// An unrelated ctx is still cancelled together with the pipeline and the values of the pipeline context
+// remain visible through it, but the deadline of the pipeline context is not. Nil ctx keeps the pipeline context.
+func WithContext[I any](pipe Stream[I], ctx context.Context) Stream[I] {
+	if ctx == nil {
+		ctx = pipe.ctx
+	}
+
+	output := make(chan I)
+	stopper := newStopper()
+	ctx, cancel := context.WithCancel(boundContext{Context: ctx, pipeline: pipe.ctx})
+	go func() { // cancels ctx with the pipeline, in case ctx is not derived from it
+		defer cancel()
+
+		select {
+		case <-pipe.ctx.Done():
+		case <-ctx.Done():
+		}
+	}()
+
+	goStep(pipe.eg, pipe.ctx, "WithContext", Options[I]{}, func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err() // fails the group, so preceding steps are stopped too
+			case elem, ok := <-pipe.in:
+				if !ok {
+					return nil
+				}
+
+				if err := emit(ctx, stopper, output, elem); err != nil {
+					return err
+				}
+			}
+		}
+	})
+
+	return Stream[I]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     ctx,
+		stopper: stopper,
+	}
+}
+
+// boundContext is the context set with WithContext, which falls back to the values of the pipeline context.
+type boundContext struct {
+	context.Context
+	pipeline context.Context
+}
+
+func (c boundContext) Value(key any) any {
+	if value := c.Context.Value(key); value != nil {
+		return value
+	}
+
+	return c.pipeline.Value(key)
+}
+
+// PipelineError is returned by terminals when the pipeline fails.
+// It wraps the first error occurred in the pipeline and keeps the context of the failure.
+type PipelineError struct {
+	// Err is the first error occurred in the pipeline.
+	Err error
+	// Step is the name of the function which started the failed step, e.g. "Map" or "ForEach".
+	// Functions built on top of other steps report the underlying step, e.g. "ParFilterMap" for ParMap.
+	// It is empty when the pipeline was stopped by cancellation of its context.
+	Step string
+	// Processed is the number of elements successfully processed by the terminal before the failure.
+	Processed int
+	// Cancelled reports whether the pipeline was stopped by context cancellation or deadline,
+	// rather than by an error returned from one of the steps.
+	Cancelled bool
+	// Cause is the cause of the pipeline context cancellation, see context.Cause.
+	// It is the first error of the pipeline, unless the context passed to the source was cancelled before,
+	// in which case it is the cause of that cancellation. On Go versions before 1.20 it is the context error.
+	Cause error
+}
+
+func (e *PipelineError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PipelineError) Unwrap() error {
+	return e.Err
+}
+
+func newPipelineError(ctx context.Context, err error, processed int) error {
+	if err == nil {
+		return nil
+	}
+
+	name, err := unmark(err)
+	_, cancelCause := unmark(cause(ctx))
+
+	return &PipelineError{
+		Err:       err,
+		Step:      name,
+		Processed: processed,
+		Cancelled: errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded),
+		Cause:     cancelCause,
+	}
+}
+
+// ForEach processes each element in the stream using the given callback function.
+// If callback returns error or context is cancelled during processing, ForEach stops and returns *PipelineError.
+func ForEach[I any](pipe Stream[I], callback func(context.Context, I) error) error {
+	processed := 0
+	goStep(pipe.eg, pipe.ctx, "ForEach", Options[I]{}, func() error {
+		for elem := range pipe.in {
+			if pipe.ctx.Err() != nil {
+				return pipe.ctx.Err()
+			}
+
+			if err := callback(pipe.ctx, elem); err != nil {
+				return err
+			}
+			processed++
+		}
+
+		return nil
+	})
+
+	return newPipelineError(pipe.ctx, pipe.eg.Wait(), processed)
+}
+
+// Reduce reduces a stream to a value which is the accumulated result of running each element in collection
+// through accumulator, where each successive invocation is supplied the return value of the previous.
+// If accum returns error or context is cancelled during processing, Reduce stops and returns *PipelineError.
+//
+//nolint:ireturn // ireturn suggests to return `any`, but we need to return specific type
+func Reduce[I any, R any](pipe Stream[I], accum func(R, I) (R, error), initial R) (R, error) {
+	fn := func(ctx context.Context, elem I) (err error) {
+		initial, err = accum(initial, elem) // a little bit of magical, but it works
+
+		return
+	}
+
+	err := ForEach(pipe, fn)
+
+	return initial, err
+}
+
+// Collect collects all elements from the stream into a slice.
+// For a stream of a known approximate size, CollectGrow preallocates the slice to avoid reallocations.
+// If context is cancelled during processing, Collect stops and returns *PipelineError.
+func Collect[I any](p Stream[I]) ([]I, error) {
+	return Reduce(
+		p,
+		func(acc []I, v I) ([]I, error) {
+			return append(acc, v), nil
+		},
+		[]I{},
+	)
+}
+
+// CollectInto appends all elements from the stream to dst and returns the extended slice, like append.
+// It allows to reuse the same slice, e.g. dst[:0], to collect streams repeatedly without allocations.
+// If context is cancelled during processing, CollectInto stops and returns the slice with elements
+// collected so far and *PipelineError.
+func CollectInto[I any](pipe Stream[I], dst []I) ([]I, error) {
+	return Reduce(
+		pipe,
 		func(acc []I, v I) ([]I, error) {
 			return append(acc, v), nil
 		},
+		dst,
+	)
+}
+
+// Drain consumes all elements of the stream and discards them.
+// It is useful for pipelines, which are run only for side effects of their steps.
+// If context is cancelled during processing, Drain stops and returns *PipelineError.
+func Drain[I any](pipe Stream[I]) error {
+	return ForEach(pipe, func(context.Context, I) error { return nil })
+}
+
+// CollectGrow collects all elements from the stream into a slice, like Collect,
+// but preallocates initialCap elements, so no reallocations happen until the stream exceeds it.
+// Beyond initialCap the slice grows as with append. The returned slice capacity is trimmed to its length.
+// If context is cancelled during processing, CollectGrow stops and returns *PipelineError.
+func CollectGrow[I any](pipe Stream[I], initialCap int) ([]I, error) {
+	if initialCap < 0 {
+		initialCap = 0
+	}
+	result := make([]I, 0, initialCap)
+
+	err := ForEach(pipe, func(_ context.Context, elem I) error {
+		result = append(result, elem)
+
+		return nil
+	})
+
+	return result[:len(result):len(result)], err
+}
+
+// InvariantError is returned by CollectChecked when invariant is violated.
+type InvariantError struct {
+	// Index is a position of the element in the stream, which violated invariant.
+	Index int
+	// Err is an error returned by invariant function.
+	Err error
+}
+
+func (e *InvariantError) Error() string {
+	return fmt.Sprintf("invariant violated at index %d: %s", e.Index, e.Err)
+}
+
+func (e *InvariantError) Unwrap() error {
+	return e.Err
+}
+
+// CollectChecked collects all elements from the stream into a slice,
+// validating invariant between each pair of consecutive elements.
+// If invariant returns error, CollectChecked stops and returns *PipelineError wrapping *InvariantError,
+// which holds the position of the violating element.
+// If context is cancelled during processing, CollectChecked stops and returns error.
+func CollectChecked[I any](pipe Stream[I], invariant func(prev, cur I) error) ([]I, error) {
+	return Reduce(
+		pipe,
+		func(acc []I, elem I) ([]I, error) {
+			if len(acc) > 0 {
+				if err := invariant(acc[len(acc)-1], elem); err != nil {
+					return acc, &InvariantError{Index: len(acc), Err: err}
+				}
+			}
+
+			return append(acc, elem), nil
+		},
+		[]I{},
+	)
+}
+
+// CollectWithDigest collects all elements from the stream into a slice and computes SHA-256 digest of the sequence.
+// The digest is computed over per-element hashes returned by hashElem, each prefixed with its length,
+// so it depends on the order of the elements and is the same for the same sequence between runs.
+// If context is cancelled during processing, CollectWithDigest stops and returns *PipelineError.
+func CollectWithDigest[I any](pipe Stream[I], hashElem func(I) []byte) ([]I, [32]byte, error) {
+	digest := sha256.New()
+	var size [8]byte
+
+	result, err := Reduce(
+		pipe,
+		func(acc []I, elem I) ([]I, error) {
+			h := hashElem(elem)
+			binary.BigEndian.PutUint64(size[:], uint64(len(h)))
+			digest.Write(size[:]) // hash.Hash never returns an error
+			digest.Write(h)
+
+			return append(acc, elem), nil
+		},
 		[]I{},
 	)
+
+	var sum [32]byte
+	copy(sum[:], digest.Sum(nil))
+
+	return result, sum, err
+}
+
+// stepError marks an error returned by a step with the step name.
+type stepError struct {
+	step string
+	err  error
+}
+
+func (e *stepError) Error() string {
+	return e.err.Error()
+}
+
+func (e *stepError) Unwrap() error {
+	return e.err
+}
+
+// goStep starts the goroutine of a step in eg, wrapped with step.
+// If the step is configured with an option, which it does not support, the step fails with ErrUnsupportedOption
+// and the goroutine starts only after the error cancels the pipeline, so it just closes the output of the step.
+func goStep[T any](eg *errgroup.Group, ctx context.Context, name string, opts Options[T], fn func() error) {
+	if err := opts.specific.unsupported(); err != nil {
+		eg.Go(step(ctx, name, opts, func() error { return err }))
+		eg.Go(func() error {
+			<-ctx.Done()
+			return step(ctx, name, opts, fn)()
+		})
+
+		return
+	}
+
+	eg.Go(step(ctx, name, opts, fn))
+}
+
+// step wraps the goroutine of a step, so its error is reported with the step name
+// and to the error handler of the step, if it is set with WithErrorHandler.
+// Errors caused by the context cancellation are not marked, as they are not failures of the step.
+// A step stopped by the consumer of its output ends without error.
+func step[T any](ctx context.Context, name string, opts Options[T], fn func() error) func() error {
+	return func() error {
+		err := fn()
+		if err == nil || errors.Is(err, errStopped) {
+			return nil
+		}
+
+		cancelled := ctx.Err() != nil && errors.Is(err, ctx.Err())
+		if opts.ErrorHandler != nil && (!cancelled || opts.HandleCancel) {
+			opts.ErrorHandler(err)
+		}
+		if cancelled {
+			return err
+		}
+
+		return &stepError{step: name, err: err}
+	}
+}
+
+// unmark returns the name of the step which returned err and the original error.
+func unmark(err error) (string, error) {
+	var stepErr *stepError
+	if errors.As(err, &stepErr) {
+		return stepErr.step, stepErr.err
+	}
+
+	return "", err
+}
+
+// First returns the first element of the stream and true, or the zero value and false if the stream is empty.
+// The preceding steps are stopped after the first element, same as with Take.
+// If context is cancelled during processing, First stops and returns the zero value and *PipelineError.
+func First[I any](pipe Stream[I]) (I, bool, error) {
+	var (
+		first I
+		found bool
+	)
+	err := ForEach(Take(pipe, 1), func(_ context.Context, elem I) error {
+		first, found = elem, true
+
+		return nil
+	})
+	if err != nil {
+		var zero I
+
+		return zero, false, err
+	}
+
+	return first, found, nil
+}
+
+// Last returns the last element of the stream and true, or the zero value and false if the stream is empty.
+// It consumes the whole stream.
+// If context is cancelled during processing, Last stops and returns the zero value and *PipelineError.
+func Last[I any](pipe Stream[I]) (I, bool, error) {
+	var (
+		last  I
+		found bool
+	)
+	err := ForEach(pipe, func(_ context.Context, elem I) error {
+		last, found = elem, true
+
+		return nil
+	})
+	if err != nil {
+		var zero I
+
+		return zero, false, err
+	}
+
+	return last, found, nil
+}
+
+// Count consumes the stream and returns the number of elements.
+// If context is cancelled during processing, Count stops and returns the number of elements counted so far
+// and *PipelineError.
+func Count[I any](pipe Stream[I]) (int, error) {
+	return Reduce(pipe, func(acc int, _ I) (int, error) { return acc + 1, nil }, 0)
+}
+
+// Find returns the first element of the stream for which pred returns true, and true.
+// If no element matches, Find returns the zero value and false.
+// The preceding steps are stopped as soon as the element is found, same as with First.
+// If pred returns error or context is cancelled during processing, Find stops and returns *PipelineError.
+func Find[I any](pipe Stream[I], pred func(context.Context, I) (bool, error)) (I, bool, error) {
+	return First(Filter(pipe, pred))
+}
+
+// AnyMatch returns true if pred returns true for any element of the stream.
+// The preceding steps are stopped as soon as the matching element is found.
+// If pred returns error or context is cancelled during processing, AnyMatch stops and returns *PipelineError.
+func AnyMatch[I any](pipe Stream[I], pred func(context.Context, I) (bool, error)) (bool, error) {
+	_, found, err := Find(pipe, pred)
+
+	return found, err
+}
+
+// AllMatch returns true if pred returns true for all elements of the stream, or the stream is empty.
+// The preceding steps are stopped as soon as the element not matching pred is found.
+// If pred returns error or context is cancelled during processing, AllMatch stops and returns *PipelineError.
+func AllMatch[I any](pipe Stream[I], pred func(context.Context, I) (bool, error)) (bool, error) {
+	found, err := AnyMatch(pipe, func(ctx context.Context, elem I) (bool, error) {
+		ok, err := pred(ctx, elem)
+
+		return !ok, err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return !found, nil
+}
+
+// Min returns the smallest element of the stream according to less, and true,
+// or the zero value and false if the stream is empty. If several elements are the smallest, the first one is returned.
+// If context is cancelled during processing, Min stops and returns the smallest element so far and *PipelineError.
+func Min[I any](pipe Stream[I], less func(I, I) bool) (I, bool, error) {
+	return extreme(pipe, less)
+}
+
+// Max returns the largest element of the stream according to less, and true,
+// or the zero value and false if the stream is empty. If several elements are the largest, the first one is returned.
+// If context is cancelled during processing, Max stops and returns the largest element so far and *PipelineError.
+func Max[I any](pipe Stream[I], less func(I, I) bool) (I, bool, error) {
+	return extreme(pipe, func(a, b I) bool { return less(b, a) })
+}
+
+// extreme returns the first element, for which no other element is less.
+func extreme[I any](pipe Stream[I], less func(I, I) bool) (I, bool, error) {
+	var (
+		best  I
+		found bool
+	)
+	err := ForEach(pipe, func(_ context.Context, elem I) error {
+		if !found || less(elem, best) {
+			best, found = elem, true
+		}
+
+		return nil
+	})
+
+	return best, found, err
+}
+
+// ToChannel sends all elements of the stream into out, blocking until out accepts each of them.
+// It does not close out, as it is owned by the caller.
+// If context is cancelled during processing, ToChannel stops sending and returns *PipelineError.
+func ToChannel[I any](pipe Stream[I], out chan<- I) error {
+	return ForEach(pipe, func(ctx context.Context, elem I) error {
+		return push(ctx, out, elem)
+	})
+}
+
+// ToMap collects all elements from the stream into a map, with keys and values returned by keyVal.
+// If several elements have the same key, the value of the last one is kept.
+// If context is cancelled during processing, ToMap stops and returns *PipelineError.
+func ToMap[I any, K comparable, V any](pipe Stream[I], keyVal func(I) (K, V)) (map[K]V, error) {
+	return Reduce(
+		pipe,
+		func(acc map[K]V, elem I) (map[K]V, error) {
+			k, v := keyVal(elem)
+			acc[k] = v
+
+			return acc, nil
+		},
+		make(map[K]V),
+	)
+}
+
+// CountBy consumes the stream and returns the number of elements for each key returned by key.
+// If context is cancelled during processing, CountBy stops and returns the counts of the elements counted so far
+// and *PipelineError.
+func CountBy[I any, K comparable](pipe Stream[I], key func(I) K) (map[K]int, error) {
+	return Reduce(
+		pipe,
+		func(acc map[K]int, elem I) (map[K]int, error) {
+			acc[key(elem)]++
+
+			return acc, nil
+		},
+		make(map[K]int),
+	)
+}
+
+// errStopped is returned by emit when the consumer of the output does not need more elements.
+// It stops the step without failing the pipeline.
+var errStopped = errors.New("stopped")
+
+// stopper signals the step, that the consumer of its output does not need more elements.
+// A nil stopper is never stopped.
+type stopper struct {
+	once sync.Once
+	done chan struct{}
+}
+
+func newStopper() *stopper {
+	return &stopper{done: make(chan struct{})}
+}
+
+func (s *stopper) stop() {
+	if s != nil {
+		s.once.Do(func() { close(s.done) })
+	}
+}
+
+func (s *stopper) stopped() <-chan struct{} {
+	if s == nil {
+		return nil
+	}
+
+	return s.done
+}
+
+// emit sends item to the output of a step, like push.
+// It returns errStopped if the consumer of the output is stopped.
+func emit[T any](ctx context.Context, s *stopper, ch chan<- T, item T) error {
+	if err := dropped(s); err != nil { // do not send into the buffer of a stopped consumer
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.stopped():
+		return errStopped
+	case ch <- item:
+		return nil
+	}
+}
+
+// dropped returns errStopped if the consumer of the output is stopped.
+// Steps which consume elements without emitting them check it on each dropped element,
+// otherwise they would read the input no one needs anymore until it ends.
+func dropped(s *stopper) error {
+	select {
+	case <-s.stopped():
+		return errStopped
+	default:
+		return nil
+	}
 }
 
 func push[T any](ctx context.Context, ch chan<- T, item T) error {
@@ -358,3 +2125,13 @@ func push[T any](ctx context.Context, ch chan<- T, item T) error {
 		return nil
 	}
 }
+
+func pull[T any](ctx context.Context, ch <-chan T) (T, bool, error) {
+	select {
+	case <-ctx.Done():
+		var zero T
+		return zero, false, ctx.Err()
+	case item, ok := <-ch:
+		return item, ok, nil
+	}
+}
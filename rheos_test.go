@@ -3,11 +3,17 @@ package rheos_test
 import (
 	"context"
 	"errors"
+	"fmt"
 	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/dmksnnk/rheos"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -152,6 +158,69 @@ func TestUnitForEach(t *testing.T) {
 	})
 }
 
+func TestUnitPipelineError(t *testing.T) {
+	t.Run("step error", func(t *testing.T) {
+		p := rheos.Map(newProducer(context.Background(), 10), func(_ context.Context, v int) (int, error) {
+			if v == 5 {
+				return 0, errTest
+			}
+			return v, nil
+		})
+		processed := 0
+		err := rheos.ForEach(p, func(_ context.Context, v int) error {
+			processed++
+			return nil
+		})
+
+		var pErr *rheos.PipelineError
+		if !errors.As(err, &pErr) {
+			t.Fatalf("unexpected error: %v, want PipelineError", err)
+		}
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+		if pErr.Processed != processed {
+			t.Errorf("processed %d, want %d", pErr.Processed, processed)
+		}
+		if pErr.Cancelled {
+			t.Error("should not be cancelled")
+		}
+		if pErr.Step != "Map" {
+			t.Errorf("step %q, want %q", pErr.Step, "Map")
+		}
+		if !errors.Is(pErr.Cause, errTest) {
+			t.Errorf("unexpected cause: %v, want: %v", pErr.Cause, errTest)
+		}
+		if err.Error() != errTest.Error() {
+			t.Errorf("unexpected message: %q, want: %q", err.Error(), errTest.Error())
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := rheos.Collect(newProducer(ctx, 10))
+		var pErr *rheos.PipelineError
+		if !errors.As(err, &pErr) {
+			t.Fatalf("unexpected error: %v, want PipelineError", err)
+		}
+		if !pErr.Cancelled {
+			t.Error("should be cancelled")
+		}
+		if pErr.Step != "" {
+			t.Errorf("step %q, want none", pErr.Step)
+		}
+	})
+
+	t.Run("no error", func(t *testing.T) {
+		_, err := rheos.Collect(newProducer(context.Background(), 10))
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
 func TestUnitReduce(t *testing.T) {
 	t.Run("count items", func(t *testing.T) {
 		num := int(rand.Int31n(100))
@@ -190,114 +259,2747 @@ func TestUnitReduce(t *testing.T) {
 	})
 }
 
-func TestUnitBuffered(t *testing.T) {
-	order := make(chan string)
-	num := 5
-
-	var eg errgroup.Group
-	eg.Go(func() error {
-		defer close(order)
+func TestUnitMapFallback(t *testing.T) {
+	t.Run("uses fallback on error", func(t *testing.T) {
+		p := newProducer(context.Background(), 6)
+		mapped := rheos.MapFallback(
+			p,
+			func(_ context.Context, v int) (int, error) {
+				if v%2 == 0 {
+					return 0, errTest
+				}
+				return v, nil
+			},
+			func(_ context.Context, v int) (int, error) {
+				return -v, nil
+			},
+		)
+		got, err := rheos.Collect(mapped)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{0, 1, -2, 3, -4, 5}, got)
+	})
 
-		p := newProducer(context.Background(), num)
-		buf := rheos.Filter(
+	t.Run("both fail", func(t *testing.T) {
+		errFallback := errors.New("fallback error")
+		p := newProducer(context.Background(), 6)
+		mapped := rheos.MapFallback(
 			p,
-			func(ctx context.Context, i int) (bool, error) {
-				order <- "buffered"
-				return true, nil
+			func(_ context.Context, v int) (int, error) {
+				return 0, errTest
+			},
+			func(_ context.Context, v int) (int, error) {
+				return 0, errFallback
 			},
-			rheos.WithBuffer[int](num),
 		)
-		unbuf := rheos.Filter(
-			buf,
-			func(ctx context.Context, i int) (bool, error) {
-				time.Sleep(10 * time.Millisecond) // simulate work
-				order <- "unbuffered"
-				return true, nil
+		_, err := rheos.Collect(mapped)
+		if !errors.Is(err, errFallback) {
+			t.Errorf("unexpected error: %v, want: %v", err, errFallback)
+		}
+	})
+
+	t.Run("context cancelled skips fallback", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		p := newProducer(ctx, 6)
+		fallbackCalled := false
+		mapped := rheos.MapFallback(
+			p,
+			func(_ context.Context, v int) (int, error) {
+				cancel()
+				return 0, errTest
+			},
+			func(_ context.Context, v int) (int, error) {
+				fallbackCalled = true
+				return v, nil
 			},
 		)
-		return rheos.ForEach(unbuf, func(_ context.Context, i int) error {
-			return nil
-		})
+		_, err := rheos.Collect(mapped)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+		if fallbackCalled {
+			t.Error("fallback should not be called after context is cancelled")
+		}
 	})
+}
 
-	// fast step with buffer can quickly fill the buffer
-	// and then slow step without buffer will start processing
-	wantResult := []string{
-		"buffered",
-		"buffered",
-		"buffered",
-		"buffered",
-		"buffered",
-		"unbuffered",
-		"unbuffered",
-		"unbuffered",
-		"unbuffered",
-		"unbuffered",
+func TestUnitRecover(t *testing.T) {
+	errSkip := errors.New("skip")
+	errDefault := errors.New("default")
+	mapper := func(_ context.Context, v int) (int, error) {
+		switch v {
+		case 1:
+			return 0, errSkip
+		case 2:
+			return 0, errDefault
+		case 3:
+			return 0, errTest
+		}
+		return v * 10, nil
 	}
-
-	var result []string
-	for i := range order {
-		result = append(result, i)
+	handler := func(_ context.Context, v int, err error) (int, bool, error) {
+		switch {
+		case errors.Is(err, errSkip):
+			return 0, false, nil
+		case errors.Is(err, errDefault):
+			return -v, true, nil
+		}
+		return 0, false, err
 	}
 
-	if err := eg.Wait(); err != nil {
-		t.Fatalf("unexpected error: %v", err)
-	}
-	assertSlicesEqual(t, wantResult, result)
+	t.Run("skips and defaults", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.Recover(newProducer(context.Background(), 3), mapper, handler))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{0, -2}, got)
+	})
+
+	t.Run("fatal error", func(t *testing.T) {
+		_, err := rheos.Collect(rheos.Recover(newProducer(context.Background(), 5), mapper, handler))
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var handled bool
+		cancelling := func(ctx context.Context, _ int) (int, error) {
+			cancel()
+			return 0, ctx.Err()
+		}
+		recovered := rheos.Recover(newProducer(ctx, 5), cancelling, func(context.Context, int, error) (int, bool, error) {
+			handled = true
+			return 0, true, nil
+		})
+		_, err := rheos.Collect(recovered)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+		if handled {
+			t.Error("handler called for context cancellation")
+		}
+	})
 }
 
-func TestUnitFromChannel(t *testing.T) {
-	t.Run("collect items", func(t *testing.T) {
-		num := int(rand.Int31n(100) + 10)
-		input := make(chan int, num)
-		go func() {
-			defer close(input)
-			for i := 0; i < num; i++ {
-				input <- i
+func TestUnitPeek(t *testing.T) {
+	t.Run("passes elements through", func(t *testing.T) {
+		var seen []int
+		peeked := rheos.Peek(newProducer(context.Background(), 5), func(_ context.Context, v int) error {
+			seen = append(seen, v)
+			return nil
+		})
+		got, err := rheos.Collect(peeked)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(5), got)
+		assertSlicesEqual(t, intRange(5), seen)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		peeked := rheos.Peek(newProducer(context.Background(), 5), func(_ context.Context, v int) error {
+			if v == 2 {
+				return errTest
 			}
-		}()
+			return nil
+		})
+		_, err := rheos.Collect(peeked)
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+}
 
-		p := rheos.FromChannel(context.Background(), input)
-		got, err := rheos.Collect(p)
+func TestUnitMapReduceEmit(t *testing.T) {
+	sum := func(acc, v int) int { return acc + v }
+	total := func(acc int) string { return "total: " + strconv.Itoa(acc) }
 
-		want := intRange(num)
+	t.Run("appends summary", func(t *testing.T) {
+		p := rheos.FromSlice(context.Background(), []int{1, 2, 3})
+		rows := rheos.MapReduceEmit(
+			p,
+			func(_ context.Context, v int) (string, error) {
+				return strconv.Itoa(v), nil
+			},
+			sum,
+			total,
+			0,
+		)
+		got, err := rheos.Collect(rows)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
-		assertSlicesEqual(t, want, got)
+		assertSlicesEqual(t, []string{"1", "2", "3", "total: 6"}, got)
 	})
 
-	t.Run("context cancelled", func(t *testing.T) {
-		ctx, cancel := context.WithCancel(context.Background())
+	t.Run("transform error", func(t *testing.T) {
+		p := rheos.FromSlice(context.Background(), []int{1, 2, 3})
+		rows := rheos.MapReduceEmit(
+			p,
+			func(_ context.Context, v int) (string, error) {
+				return "", errTest
+			},
+			sum,
+			total,
+			0,
+		)
+		_, err := rheos.Collect(rows)
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+}
+
+func TestUnitCollectChecked(t *testing.T) {
+	errNotSorted := errors.New("not sorted")
+	sorted := func(prev, cur int) error {
+		if cur < prev {
+			return errNotSorted
+		}
+		return nil
+	}
 
+	t.Run("invariant holds", func(t *testing.T) {
 		num := int(rand.Int31n(100) + 10)
-		input := make(chan int, num)
-		go func() {
-			defer close(input)
-			for i := 0; i < num; i++ {
-				if i >= num/2 {
-					cancel()
-					return
-				}
+		got, err := rheos.CollectChecked(newProducer(context.Background(), num), sorted)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(num), got)
+	})
 
-				input <- i
-			}
-		}()
+	t.Run("invariant violated", func(t *testing.T) {
+		p := rheos.FromSlice(context.Background(), []int{1, 2, 5, 3, 4})
+		got, err := rheos.CollectChecked(p, sorted)
 
-		p := rheos.FromChannel(ctx, input)
-		_, err := rheos.Collect(p)
+		var invErr *rheos.InvariantError
+		if !errors.As(err, &invErr) {
+			t.Fatalf("unexpected error: %v, want InvariantError", err)
+		}
+		if invErr.Index != 3 {
+			t.Errorf("unexpected index: %d, want: 3", invErr.Index)
+		}
+		if !errors.Is(err, errNotSorted) {
+			t.Errorf("unexpected error: %v, want: %v", err, errNotSorted)
+		}
+		assertSlicesEqual(t, []int{1, 2, 5}, got)
+	})
+}
+
+func TestUnitCollectWithDigest(t *testing.T) {
+	hashElem := func(s string) []byte {
+		return []byte(s)
+	}
+	digest := func(t *testing.T, vals []string) [32]byte {
+		t.Helper()
+		got, sum, err := rheos.CollectWithDigest(rheos.FromSlice(context.Background(), vals), hashElem)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, vals, got)
+		return sum
+	}
+
+	t.Run("same sequence same digest", func(t *testing.T) {
+		if digest(t, []string{"a", "b", "c"}) != digest(t, []string{"a", "b", "c"}) {
+			t.Error("digests of the same sequence differ")
+		}
+	})
+
+	t.Run("order sensitive", func(t *testing.T) {
+		if digest(t, []string{"a", "b", "c"}) == digest(t, []string{"c", "b", "a"}) {
+			t.Error("digests of reordered sequence are equal")
+		}
+	})
+
+	t.Run("element boundaries", func(t *testing.T) {
+		if digest(t, []string{"ab", "c"}) == digest(t, []string{"a", "bc"}) {
+			t.Error("digests of differently split sequence are equal")
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, _, err := rheos.CollectWithDigest(rheos.FromSlice(ctx, []string{"a"}), hashElem)
 		if !errors.Is(err, context.Canceled) {
 			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
 		}
 	})
 }
 
-func newProducer(ctx context.Context, num int) rheos.Stream[int] {
-	return rheos.FromIter(ctx, func(yield func(v int) bool) error {
-		for i := 0; i < num; i++ {
-			if !yield(i) {
-				break
-			}
+func TestUnitEnforceMonotonic(t *testing.T) {
+	less := func(a, b int) bool { return a < b }
+
+	t.Run("reorders within buffer", func(t *testing.T) {
+		p := rheos.FromSlice(context.Background(), []int{1, 3, 2, 4, 6, 5, 7, 9, 8})
+		got, err := rheos.Collect(rheos.EnforceMonotonic(p, less, 2))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{1, 2, 3, 4, 5, 6, 7, 8, 9}, got)
+	})
+
+	t.Run("too late errors", func(t *testing.T) {
+		p := rheos.FromSlice(context.Background(), []int{2, 3, 4, 5, 1})
+		_, err := rheos.Collect(rheos.EnforceMonotonic(p, less, 2))
+		if !errors.Is(err, rheos.ErrOutOfOrder) {
+			t.Errorf("unexpected error: %v, want: %v", err, rheos.ErrOutOfOrder)
+		}
+	})
+
+	t.Run("too late dropped", func(t *testing.T) {
+		p := rheos.FromSlice(context.Background(), []int{2, 3, 4, 5, 1, 6})
+		got, err := rheos.Collect(rheos.EnforceMonotonicDrop(p, less, 2))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{2, 3, 4, 5, 6}, got)
+	})
+}
+
+type memStore struct {
+	keys    map[string]bool
+	markErr error
+}
+
+func (s *memStore) Seen(_ context.Context, key string) (bool, error) {
+	return s.keys[key], nil
+}
+
+func (s *memStore) Mark(_ context.Context, key string) error {
+	if s.markErr != nil {
+		return s.markErr
+	}
+	s.keys[key] = true
+	return nil
+}
+
+func TestUnitDedupePersistent(t *testing.T) {
+	t.Run("skips seen", func(t *testing.T) {
+		store := &memStore{keys: map[string]bool{"2": true}}
+		p := rheos.FromSlice(context.Background(), []int{1, 2, 3, 1, 4})
+
+		got, err := rheos.Collect(rheos.DedupePersistent(p, strconv.Itoa, store))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{1, 3, 4}, got)
+
+		// next run skips everything
+		p = rheos.FromSlice(context.Background(), []int{1, 2, 3, 4})
+		got, err = rheos.Collect(rheos.DedupePersistent(p, strconv.Itoa, store))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{}, got)
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		store := &memStore{keys: map[string]bool{}, markErr: errTest}
+		p := rheos.FromSlice(context.Background(), []int{1, 2, 3})
+
+		_, err := rheos.Collect(rheos.DedupePersistent(p, strconv.Itoa, store))
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+}
+
+func TestUnitCollectGrow(t *testing.T) {
+	t.Run("collect items", func(t *testing.T) {
+		num := int(rand.Int31n(100) + 10)
+		got, err := rheos.CollectGrow(newProducer(context.Background(), num), 4)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(num), got)
+		if cap(got) != len(got) {
+			t.Errorf("capacity %d is not trimmed to length %d", cap(got), len(got))
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := rheos.CollectGrow(newProducer(ctx, 10), 4)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestUnitCollectInto(t *testing.T) {
+	t.Run("appends", func(t *testing.T) {
+		got, err := rheos.CollectInto(newProducer(context.Background(), 3), []int{-1})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{-1, 0, 1, 2}, got)
+	})
+
+	t.Run("reuses slice", func(t *testing.T) {
+		dst := make([]int, 0, 10)
+		for i := 0; i < 3; i++ {
+			got, err := rheos.CollectInto(newProducer(context.Background(), 5), dst[:0])
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assertSlicesEqual(t, intRange(5), got)
+			if &got[0] != &dst[:1][0] {
+				t.Error("want the same backing array")
+			}
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := rheos.CollectInto(newProducer(ctx, 10), nil)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func BenchmarkCollect(b *testing.B) {
+	const num = 1_000_000
+	vals := intRange(num)
+
+	b.Run("Collect", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := rheos.Collect(rheos.FromSlice(context.Background(), vals)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	for _, initialCap := range []int{num / 1000, num / 10, num} {
+		initialCap := initialCap
+		b.Run("CollectGrow/cap="+strconv.Itoa(initialCap), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := rheos.CollectGrow(rheos.FromSlice(context.Background(), vals), initialCap); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestUnitBatchTimeout(t *testing.T) {
+	// gaps before each element
+	gapped := func(ctx context.Context, gaps ...time.Duration) rheos.Stream[int] {
+		return rheos.FromIter(ctx, func(yield func(int) bool) error {
+			for i, gap := range gaps {
+				time.Sleep(gap)
+				if !yield(i) {
+					return nil
+				}
+			}
+			return nil
+		})
+	}
+
+	t.Run("timeout since first element", func(t *testing.T) {
+		input := gapped(context.Background(), 0, 0, 100*time.Millisecond, 30*time.Millisecond, 30*time.Millisecond)
+		got, err := rheos.Collect(rheos.BatchTimeout(input, 10, 50*time.Millisecond))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// the second batch starts at 100ms and times out at 150ms, so the element at 130ms joins it, at 160ms does not
+		want := [][]int{{0, 1}, {2, 3}, {4}}
+		if len(got) != len(want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		for i := range want {
+			assertSlicesEqual(t, want[i], got[i])
+		}
+	})
+
+	t.Run("full batch", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.BatchTimeout(newProducer(context.Background(), 5), 2, time.Hour))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := [][]int{{0, 1}, {2, 3}, {4}}
+		if len(got) != len(want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		for i := range want {
+			assertSlicesEqual(t, want[i], got[i])
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		input := rheos.FromIter(ctx, func(yield func(int) bool) error {
+			yield(1)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		_, err := rheos.Collect(rheos.BatchTimeout(input, 10, time.Hour))
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.DeadlineExceeded)
+		}
+	})
+}
+
+func TestUnitBatchWeighted(t *testing.T) {
+	weight := func(v int) int { return v }
+
+	t.Run("bounded by weight", func(t *testing.T) {
+		input := rheos.FromSlice(context.Background(), []int{1, 2, 3, 4, 1, 1, 2})
+		got, err := rheos.Collect(rheos.BatchWeighted(input, 5, weight))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := [][]int{{1, 2}, {3}, {4, 1}, {1, 2}}
+		if len(got) != len(want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		for i := range want {
+			assertSlicesEqual(t, want[i], got[i])
+		}
+	})
+
+	t.Run("heavy element alone", func(t *testing.T) {
+		input := rheos.FromSlice(context.Background(), []int{1, 10, 2, 3, 7})
+		got, err := rheos.Collect(rheos.BatchWeighted(input, 5, weight))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := [][]int{{1}, {10}, {2, 3}, {7}}
+		if len(got) != len(want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		for i := range want {
+			assertSlicesEqual(t, want[i], got[i])
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.BatchWeighted(newProducer(context.Background(), 0), 5, weight))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != 0 {
+			t.Errorf("got %v, want no batches", got)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := rheos.Collect(rheos.BatchWeighted(newProducer(ctx, 10), 5, weight))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestUnitBatchPool(t *testing.T) {
+	var allocated int64
+	pool := &sync.Pool{New: func() any {
+		atomic.AddInt64(&allocated, 1)
+		return make([]int, 0, 10)
+	}}
+
+	sum := 0
+	err := rheos.ForEach(rheos.Batch(newProducer(context.Background(), 1000), 10, rheos.WithBatchPool[int](pool)), func(_ context.Context, batch []int) error {
+		if len(batch) != 10 {
+			t.Errorf("got batch of %d elements, want 10", len(batch))
+		}
+		for _, v := range batch {
+			sum += v
+		}
+		pool.Put(batch)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := 999 * 1000 / 2; sum != want {
+		t.Errorf("got sum %d, want %d", sum, want)
+	}
+	if a := atomic.LoadInt64(&allocated); a > 75 { // pool drops some of the slices, especially with race detector
+		t.Errorf("allocated %d slices for 100 batches, want slices reused", a)
+	}
+}
+
+func BenchmarkBatch(b *testing.B) {
+	const num = 100_000
+	vals := intRange(num)
+
+	b.Run("Batch", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := rheos.ForEach(rheos.Batch(rheos.FromSlice(context.Background(), vals), 100), func(context.Context, []int) error {
+				return nil
+			}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("WithBatchPool", func(b *testing.B) {
+		pool := &sync.Pool{}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			batches := rheos.Batch(rheos.FromSlice(context.Background(), vals), 100, rheos.WithBatchPool[int](pool))
+			if err := rheos.ForEach(batches, func(_ context.Context, batch []int) error {
+				pool.Put(batch)
+				return nil
+			}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestUnitFirst(t *testing.T) {
+	t.Run("first element", func(t *testing.T) {
+		var produced int64
+		first, ok, err := rheos.First(newInfiniteProducer(context.Background(), &produced))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !ok || first != 0 {
+			t.Errorf("got %d, %t, want 0, true", first, ok)
+		}
+		if p := atomic.LoadInt64(&produced); p > 3 {
+			t.Errorf("produced %d elements, want source stopped after the first one", p)
+		}
+	})
+
+	t.Run("empty stream", func(t *testing.T) {
+		first, ok, err := rheos.First(newProducer(context.Background(), 0))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ok || first != 0 {
+			t.Errorf("got %d, %t, want 0, false", first, ok)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, ok, err := rheos.First(newProducer(ctx, 10))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+		if ok {
+			t.Error("want no element")
+		}
+	})
+}
+
+func TestUnitLast(t *testing.T) {
+	t.Run("last element", func(t *testing.T) {
+		sums := rheos.Scan(newProducer(context.Background(), 5), func(acc, v int) (int, error) { return acc + v, nil }, 0)
+		last, ok, err := rheos.Last(sums)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !ok || last != 10 {
+			t.Errorf("got %d, %t, want 10, true", last, ok)
+		}
+	})
+
+	t.Run("empty stream", func(t *testing.T) {
+		last, ok, err := rheos.Last(newProducer(context.Background(), 0))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ok || last != 0 {
+			t.Errorf("got %d, %t, want 0, false", last, ok)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		failing := rheos.Map(newProducer(context.Background(), 10), func(_ context.Context, v int) (int, error) {
+			if v == 5 {
+				return 0, errTest
+			}
+			return v, nil
+		})
+		_, ok, err := rheos.Last(failing)
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+		if ok {
+			t.Error("want no element")
+		}
+	})
+}
+
+func TestUnitCount(t *testing.T) {
+	t.Run("count", func(t *testing.T) {
+		got, err := rheos.Count(newProducer(context.Background(), 10))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != 10 {
+			t.Errorf("got %d, want 10", got)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		mapped := rheos.Map(newProducer(ctx, 10), func(ctx context.Context, v int) (int, error) {
+			if v == 3 {
+				cancel()
+				return 0, ctx.Err()
+			}
+			return v, nil
+		})
+		got, err := rheos.Count(mapped)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+		if got > 3 {
+			t.Errorf("got %d, want at most 3 elements counted before cancellation", got)
+		}
+	})
+}
+
+func TestUnitFind(t *testing.T) {
+	greaterThan := func(n int) func(context.Context, int) (bool, error) {
+		return func(_ context.Context, v int) (bool, error) { return v > n, nil }
+	}
+
+	t.Run("found", func(t *testing.T) {
+		var produced int64
+		got, ok, err := rheos.Find(newInfiniteProducer(context.Background(), &produced), greaterThan(4))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !ok || got != 5 {
+			t.Errorf("got %d, %t, want 5, true", got, ok)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		got, ok, err := rheos.Find(newProducer(context.Background(), 5), greaterThan(10))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ok || got != 0 {
+			t.Errorf("got %d, %t, want 0, false", got, ok)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		_, ok, err := rheos.Find(newProducer(context.Background(), 5), func(context.Context, int) (bool, error) {
+			return false, errTest
+		})
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+		if ok {
+			t.Error("want no element")
+		}
+	})
+}
+
+func TestUnitAnyMatch(t *testing.T) {
+	t.Run("match", func(t *testing.T) {
+		var produced int64
+		got, err := rheos.AnyMatch(newInfiniteProducer(context.Background(), &produced), func(_ context.Context, v int) (bool, error) {
+			return v == 5, nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !got {
+			t.Error("want match")
+		}
+	})
+
+	t.Run("no match", func(t *testing.T) {
+		got, err := rheos.AnyMatch(newProducer(context.Background(), 5), func(_ context.Context, v int) (bool, error) {
+			return v > 10, nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got {
+			t.Error("want no match")
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		got, err := rheos.AnyMatch(newProducer(context.Background(), 5), func(context.Context, int) (bool, error) {
+			return false, errTest
+		})
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+		if got {
+			t.Error("want no match")
+		}
+	})
+}
+
+func TestUnitAllMatch(t *testing.T) {
+	t.Run("all match", func(t *testing.T) {
+		got, err := rheos.AllMatch(newProducer(context.Background(), 5), func(_ context.Context, v int) (bool, error) {
+			return v < 5, nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !got {
+			t.Error("want all elements to match")
+		}
+	})
+
+	t.Run("stops on mismatch", func(t *testing.T) {
+		var produced int64
+		got, err := rheos.AllMatch(newInfiniteProducer(context.Background(), &produced), func(_ context.Context, v int) (bool, error) {
+			return v < 5, nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got {
+			t.Error("want mismatch")
+		}
+	})
+
+	t.Run("empty stream", func(t *testing.T) {
+		got, err := rheos.AllMatch(newProducer(context.Background(), 0), func(context.Context, int) (bool, error) {
+			return false, nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !got {
+			t.Error("want true for empty stream")
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		got, err := rheos.AllMatch(newProducer(ctx, 5), func(context.Context, int) (bool, error) {
+			return true, nil
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+		if got {
+			t.Error("want false on error")
+		}
+	})
+}
+
+func TestUnitMinMax(t *testing.T) {
+	type item struct {
+		name  string
+		score int
+	}
+	lessScore := func(a, b item) bool { return a.score < b.score }
+	items := []item{{"b", 2}, {"a", 1}, {"c", 3}, {"a2", 1}, {"c2", 3}}
+
+	t.Run("min", func(t *testing.T) {
+		got, ok, err := rheos.Min(rheos.FromSlice(context.Background(), items), lessScore)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !ok || got != (item{"a", 1}) {
+			t.Errorf("got %v, %t, want %v, true", got, ok, item{"a", 1})
+		}
+	})
+
+	t.Run("max", func(t *testing.T) {
+		got, ok, err := rheos.Max(rheos.FromSlice(context.Background(), items), lessScore)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !ok || got != (item{"c", 3}) {
+			t.Errorf("got %v, %t, want %v, true", got, ok, item{"c", 3})
+		}
+	})
+
+	t.Run("empty stream", func(t *testing.T) {
+		got, ok, err := rheos.Max(newProducer(context.Background(), 0), lessInt)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ok || got != 0 {
+			t.Errorf("got %d, %t, want 0, false", got, ok)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		mapped := rheos.Map(newProducer(ctx, 10), func(ctx context.Context, v int) (int, error) {
+			if v == 3 {
+				cancel()
+				return 0, ctx.Err()
+			}
+			return v, nil
+		})
+		got, _, err := rheos.Max(mapped, lessInt)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+		if got > 2 {
+			t.Errorf("got %d, want the largest element before cancellation", got)
+		}
+	})
+}
+
+func TestUnitToChannel(t *testing.T) {
+	t.Run("sends all elements", func(t *testing.T) {
+		out := make(chan int)
+		errs := make(chan error, 1)
+		go func() {
+			errs <- rheos.ToChannel(newProducer(context.Background(), 5), out)
+		}()
+
+		got := make([]int, 0, 5)
+		for i := 0; i < 5; i++ {
+			got = append(got, <-out)
+		}
+		if err := <-errs; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(5), got)
+		close(out) // panics if ToChannel closed it
+	})
+
+	t.Run("cancelled while blocked", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		out := make(chan int) // nobody reads
+
+		time.AfterFunc(50*time.Millisecond, cancel)
+		err := rheos.ToChannel(newProducer(ctx, 5), out)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestUnitToMap(t *testing.T) {
+	type record struct {
+		id   int
+		name string
+	}
+
+	t.Run("last write wins", func(t *testing.T) {
+		records := []record{{1, "a"}, {2, "b"}, {1, "c"}}
+		got, err := rheos.ToMap(rheos.FromSlice(context.Background(), records), func(r record) (int, string) {
+			return r.id, r.name
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != 2 || got[1] != "c" || got[2] != "b" {
+			t.Errorf("got %v, want map[1:c 2:b]", got)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := rheos.ToMap(newProducer(ctx, 5), func(v int) (int, int) { return v, v })
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestUnitCountBy(t *testing.T) {
+	t.Run("counts per key", func(t *testing.T) {
+		got, err := rheos.CountBy(newProducer(context.Background(), 10), func(v int) int { return v % 3 })
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != 3 || got[0] != 4 || got[1] != 3 || got[2] != 3 {
+			t.Errorf("got %v, want map[0:4 1:3 2:3]", got)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		mapped := rheos.Map(newProducer(ctx, 10), func(ctx context.Context, v int) (int, error) {
+			if v == 3 {
+				cancel()
+				return 0, ctx.Err()
+			}
+			return v, nil
+		})
+		got, err := rheos.CountBy(mapped, func(v int) bool { return v%2 == 0 })
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+		if got[true] > 2 || got[false] > 1 {
+			t.Errorf("got %v, want at most the counts of 3 elements before cancellation", got)
+		}
+	})
+}
+
+func TestUnitGenerate(t *testing.T) {
+	t.Run("until no more", func(t *testing.T) {
+		next := 0
+		counter := func(context.Context) (int, bool, error) {
+			next++
+			return next, next <= 3, nil
+		}
+		got, err := rheos.Collect(rheos.Generate(context.Background(), counter))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{1, 2, 3}, got)
+	})
+
+	t.Run("infinite with take", func(t *testing.T) {
+		var calls int64
+		gen := func(context.Context) (int64, bool, error) {
+			return atomic.AddInt64(&calls, 1), true, nil
+		}
+		got, err := rheos.Collect(rheos.Take(rheos.Generate(context.Background(), gen), 3))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int64{1, 2, 3}, got)
+		if c := atomic.LoadInt64(&calls); c > 5 {
+			t.Errorf("gen called %d times, want generator stopped after around 3", c)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		gen := func(context.Context) (int, bool, error) {
+			return 0, false, errTest
+		}
+		_, err := rheos.Collect(rheos.Generate(context.Background(), gen))
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		gen := func(context.Context) (int, bool, error) {
+			cancel()
+			return 1, true, nil
+		}
+		_, err := rheos.Collect(rheos.Generate(ctx, gen))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestUnitRepeat(t *testing.T) {
+	t.Run("repeat with take", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.Take(rheos.Repeat(context.Background(), "a"), 3))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []string{"a", "a", "a"}, got)
+	})
+
+	t.Run("repeat cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err := rheos.Count(rheos.Repeat(ctx, 1))
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.DeadlineExceeded)
+		}
+	})
+
+	for _, n := range []int{0, 1, 5, -1} {
+		n := n
+		t.Run("repeat n "+strconv.Itoa(n), func(t *testing.T) {
+			got, err := rheos.Count(rheos.RepeatN(context.Background(), 1, n))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want := n
+			if want < 0 {
+				want = 0
+			}
+			if got != want {
+				t.Errorf("got %d elements, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestUnitRange(t *testing.T) {
+	for name, tc := range map[string]struct {
+		start, stop, step int
+		want              []int
+	}{
+		"up":            {start: 0, stop: 5, step: 1, want: []int{0, 1, 2, 3, 4}},
+		"up by two":     {start: 1, stop: 6, step: 2, want: []int{1, 3, 5}},
+		"down":          {start: 5, stop: 0, step: -2, want: []int{5, 3, 1}},
+		"empty up":      {start: 5, stop: 0, step: 1, want: []int{}},
+		"empty down":    {start: 0, stop: 5, step: -1, want: []int{}},
+		"start at stop": {start: 3, stop: 3, step: 1, want: []int{}},
+	} {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			got, err := rheos.Collect(rheos.Range(context.Background(), tc.start, tc.stop, tc.step))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assertSlicesEqual(t, tc.want, got)
+		})
+	}
+
+	t.Run("zero step", func(t *testing.T) {
+		_, err := rheos.Collect(rheos.Range(context.Background(), 0, 5, 0))
+		if !errors.Is(err, rheos.ErrZeroStep) {
+			t.Errorf("unexpected error: %v, want: %v", err, rheos.ErrZeroStep)
+		}
+	})
+}
+
+func TestUnitSample(t *testing.T) {
+	t.Run("every nth", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.Sample(newProducer(context.Background(), 25), 10))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{0, 10, 20}, got)
+	})
+
+	t.Run("every element", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.Sample(newProducer(context.Background(), 3), 1))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(3), got)
+	})
+
+	for _, n := range []int{0, -1} {
+		n := n
+		t.Run("not positive "+strconv.Itoa(n), func(t *testing.T) {
+			var produced int64
+			_, err := rheos.Collect(rheos.Sample(newInfiniteProducer(context.Background(), &produced), n))
+			if !errors.Is(err, rheos.ErrInvalidSample) {
+				t.Errorf("unexpected error: %v, want: %v", err, rheos.ErrInvalidSample)
+			}
+		})
+	}
+}
+
+type countingObserver struct {
+	elements  int64
+	errors    int64
+	durations int64
+}
+
+func (o *countingObserver) OnElement()               { atomic.AddInt64(&o.elements, 1) }
+func (o *countingObserver) OnError(error)            { atomic.AddInt64(&o.errors, 1) }
+func (o *countingObserver) OnDuration(time.Duration) { atomic.AddInt64(&o.durations, 1) }
+
+func TestUnitWithObserver(t *testing.T) {
+	t.Run("counts elements", func(t *testing.T) {
+		obs := &countingObserver{}
+		double := func(_ context.Context, v int) (int, error) { return v * 2, nil }
+		mapped := rheos.Map(newProducer(context.Background(), 5), double, rheos.WithObserver[int](obs))
+		parMapped := rheos.ParMap(mapped, 3, double, rheos.WithObserver[int](obs))
+		got, err := rheos.Collect(parMapped)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != 5 {
+			t.Errorf("got %d elements, want 5", len(got))
+		}
+
+		if obs.elements != 10 || obs.durations != 10 || obs.errors != 0 {
+			t.Errorf("observed %d elements, %d durations, %d errors, want 10, 10, 0", obs.elements, obs.durations, obs.errors)
+		}
+	})
+
+	t.Run("counts errors", func(t *testing.T) {
+		obs := &countingObserver{}
+		filtered := rheos.Filter(newProducer(context.Background(), 5), func(_ context.Context, v int) (bool, error) {
+			if v == 2 {
+				return false, errTest
+			}
+			return true, nil
+		}, rheos.WithObserver[int](obs))
+		_, err := rheos.Collect(filtered)
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+
+		if obs.elements != 2 || obs.durations != 3 || obs.errors != 1 {
+			t.Errorf("observed %d elements, %d durations, %d errors, want 2, 3, 1", obs.elements, obs.durations, obs.errors)
+		}
+	})
+}
+
+type recordingSpan struct {
+	trace.Span
+	name  string
+	err   error
+	ended bool
+}
+
+func (s *recordingSpan) RecordError(err error, _ ...trace.EventOption) { s.err = err }
+func (s *recordingSpan) End(...trace.SpanEndOption)                    { s.ended = true }
+
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordingSpan
+}
+
+func (tr *recordingTracer) Start(ctx context.Context, name string, _ ...trace.SpanStartOption) (context.Context, trace.Span) {
+	span := &recordingSpan{Span: trace.SpanFromContext(ctx), name: name}
+	tr.mu.Lock()
+	tr.spans = append(tr.spans, span)
+	tr.mu.Unlock()
+
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+func TestUnitWithTracer(t *testing.T) {
+	tracer := &recordingTracer{}
+	mapped := rheos.ParMap(newProducer(context.Background(), 5), 2, func(ctx context.Context, v int) (int, error) {
+		span, ok := trace.SpanFromContext(ctx).(*recordingSpan)
+		if !ok || span.ended {
+			t.Error("callback context has no active span")
+		}
+		if v == 4 {
+			return 0, errTest
+		}
+		return v, nil
+	}, rheos.WithTracer[int](tracer, "process"))
+
+	_, err := rheos.Collect(mapped)
+	if !errors.Is(err, errTest) {
+		t.Fatalf("unexpected error: %v, want: %v", err, errTest)
+	}
+
+	var failed int
+	for _, span := range tracer.spans {
+		if span.name != "process" || !span.ended {
+			t.Errorf("got span %q, ended %t, want ended span %q", span.name, span.ended, "process")
+		}
+		if span.err != nil {
+			failed++
+			if !errors.Is(span.err, errTest) {
+				t.Errorf("span recorded error %v, want: %v", span.err, errTest)
+			}
+		}
+	}
+	if failed != 1 {
+		t.Errorf("got %d spans with error, want 1", failed)
+	}
+}
+
+func TestUnitBuffer(t *testing.T) {
+	t.Run("reads ahead", func(t *testing.T) {
+		var produced int64
+		input := rheos.Peek(newProducer(context.Background(), 10), func(context.Context, int) error {
+			atomic.AddInt64(&produced, 1)
+			return nil
+		})
+		buffered := rheos.Buffer(input, 5)
+
+		var readAhead int64
+		got := make([]int, 0, 10)
+		err := rheos.ForEach(buffered, func(_ context.Context, v int) error {
+			if v == 0 {
+				time.Sleep(50 * time.Millisecond) // slow consumer, let the buffer fill up
+				readAhead = atomic.LoadInt64(&produced)
+			}
+			got = append(got, v)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(10), got)
+		if readAhead < 6 {
+			t.Errorf("read %d elements while the consumer was busy, want at least 6", readAhead)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		failing := rheos.Map(newProducer(context.Background(), 10), func(_ context.Context, v int) (int, error) {
+			if v == 5 {
+				return 0, errTest
+			}
+			return v, nil
+		})
+		_, err := rheos.Collect(rheos.Buffer(failing, 3))
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+
+	t.Run("stopped by consumer", func(t *testing.T) {
+		var produced int64
+		got, err := rheos.Collect(rheos.Take(rheos.Buffer(newInfiniteProducer(context.Background(), &produced), 3), 2))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{0, 1}, got)
+	})
+}
+
+type dropCountingObserver struct {
+	countingObserver
+	dropped int64
+}
+
+func (o *dropCountingObserver) OnDrop() { atomic.AddInt64(&o.dropped, 1) }
+
+func TestUnitBufferDropOldest(t *testing.T) {
+	t.Run("drops oldest", func(t *testing.T) {
+		obs := &dropCountingObserver{}
+		buffered := rheos.BufferDropOldest(newProducer(context.Background(), 100), 5, rheos.WithObserver[int](obs))
+
+		var got []int
+		err := rheos.ForEach(buffered, func(_ context.Context, v int) error {
+			if len(got) == 0 {
+				time.Sleep(50 * time.Millisecond) // slow consumer, the rest of elements arrive meanwhile
+			}
+			got = append(got, v)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(got) != 6 {
+			t.Fatalf("got %v, want the first received element followed by the last 5", got)
+		}
+		assertSlicesEqual(t, []int{95, 96, 97, 98, 99}, got[1:])
+		if obs.dropped != 94 {
+			t.Errorf("dropped %d elements, want 94", obs.dropped)
+		}
+	})
+
+	t.Run("drops oldest with default buffer", func(t *testing.T) {
+		ctx := rheos.WithDefaultBuffer(context.Background(), 50)
+		buffered := rheos.BufferDropOldest(newProducer(ctx, 100), 5, rheos.WithBuffer[int](50))
+
+		var got []int
+		err := rheos.ForEach(buffered, func(_ context.Context, v int) error {
+			if len(got) == 0 {
+				time.Sleep(50 * time.Millisecond)
+			}
+			got = append(got, v)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(got) != 6 {
+			t.Fatalf("got %v, want the first received element followed by the last 5", got)
+		}
+		assertSlicesEqual(t, []int{95, 96, 97, 98, 99}, got[1:])
+	})
+
+	t.Run("keeps all if consumer is fast", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.BufferDropOldest(newProducer(context.Background(), 5), 5))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(5), got)
+	})
+
+	t.Run("stopped by consumer", func(t *testing.T) {
+		var produced int64
+		got, err := rheos.Collect(rheos.Take(rheos.BufferDropOldest(newInfiniteProducer(context.Background(), &produced), 3), 1))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != 1 {
+			t.Errorf("got %d elements, want 1", len(got))
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := rheos.Collect(rheos.BufferDropOldest(newProducer(ctx, 5), 5))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+type path []string
+
+func TestUnitFlatten(t *testing.T) {
+	t.Run("named slice type", func(t *testing.T) {
+		paths := rheos.FromSlice(context.Background(), []path{{"a", "b"}, {}, {"c"}})
+		got, err := rheos.Collect(rheos.Flatten(paths))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []string{"a", "b", "c"}, got)
+	})
+
+	t.Run("arrays", func(t *testing.T) {
+		arrays := rheos.FromSlice(context.Background(), [][2]int{{1, 2}, {3, 4}})
+		slices := rheos.Map(arrays, func(_ context.Context, a [2]int) ([]int, error) { return a[:], nil })
+		got, err := rheos.Collect(rheos.Flatten(slices))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{1, 2, 3, 4}, got)
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := rheos.Collect(rheos.Flatten(rheos.FromSlice(ctx, []path{{"a"}})))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestUnitSort(t *testing.T) {
+	type item struct {
+		key, seq int
+	}
+
+	t.Run("sorts stable", func(t *testing.T) {
+		input := []item{{3, 0}, {1, 1}, {2, 2}, {1, 3}, {3, 4}, {0, 5}}
+		got, err := rheos.Collect(rheos.Sort(rheos.FromSlice(context.Background(), input), func(a, b item) bool {
+			return a.key < b.key
+		}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []item{{0, 5}, {1, 1}, {1, 3}, {2, 2}, {3, 0}, {3, 4}}, got)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.Sort(rheos.FromSlice(context.Background(), []int{}), lessInt))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != 0 {
+			t.Errorf("want empty result, got %v", got)
+		}
+	})
+
+	t.Run("stopped by Take", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.Take(rheos.Sort(rheos.FromSlice(context.Background(), []int{5, 3, 4, 1, 2}), lessInt), 2))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{1, 2}, got)
+	})
+
+	t.Run("input error", func(t *testing.T) {
+		failing := rheos.FromIter(context.Background(), func(yield func(int) bool) error {
+			yield(2)
+			yield(1)
+			return errTest
+		})
+		got, err := rheos.Collect(rheos.Sort(failing, lessInt))
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+		if len(got) != 0 {
+			t.Errorf("want no elements of incomplete input, got %v", got)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := rheos.Collect(rheos.Sort(newProducer(ctx, 10), lessInt))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestUnitTopN(t *testing.T) {
+	t.Run("greatest elements", func(t *testing.T) {
+		input := []int{5, 1, 9, 3, 7, 9, 2, 8}
+		got, err := rheos.Collect(rheos.TopN(rheos.FromSlice(context.Background(), input), 3, lessInt))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{9, 9, 8}, got)
+	})
+
+	t.Run("smallest elements", func(t *testing.T) {
+		greater := func(a, b int) bool { return a > b }
+		got, err := rheos.Collect(rheos.TopN(newProducer(context.Background(), 1000), 4, greater))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{0, 1, 2, 3}, got)
+	})
+
+	t.Run("fewer than n", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.TopN(rheos.FromSlice(context.Background(), []int{2, 3, 1}), 5, lessInt))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{3, 2, 1}, got)
+	})
+
+	t.Run("n not positive", func(t *testing.T) {
+		var produced int64
+		got, err := rheos.Collect(rheos.TopN(newInfiniteProducer(context.Background(), &produced), 0, lessInt))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != 0 {
+			t.Errorf("want empty result, got %v", got)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := rheos.Collect(rheos.TopN(newProducer(ctx, 10), 3, lessInt))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestUnitChunkBy(t *testing.T) {
+	t.Run("runs of keys", func(t *testing.T) {
+		words := rheos.FromSlice(context.Background(), []string{"apple", "avocado", "banana", "blueberry", "cherry", "apricot"})
+		chunks := rheos.ChunkBy(words, func(w string) byte { return w[0] })
+		got, err := rheos.Collect(chunks)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		want := [][]string{{"apple", "avocado"}, {"banana", "blueberry"}, {"cherry"}, {"apricot"}}
+		if len(got) != len(want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		for i := range want {
+			assertSlicesEqual(t, want[i], got[i])
+		}
+	})
+
+	t.Run("empty stream", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.ChunkBy(newProducer(context.Background(), 0), func(v int) int { return v }))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != 0 {
+			t.Errorf("got %v, want no chunks", got)
+		}
+	})
+
+	t.Run("stopped by consumer", func(t *testing.T) {
+		var produced int64
+		chunks := rheos.ChunkBy(newInfiniteProducer(context.Background(), &produced), func(v int) int { return v / 3 })
+		got, err := rheos.Collect(rheos.Take(chunks, 2))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != 2 {
+			t.Fatalf("got %v, want 2 chunks", got)
+		}
+		assertSlicesEqual(t, []int{0, 1, 2}, got[0])
+		assertSlicesEqual(t, []int{3, 4, 5}, got[1])
+	})
+}
+
+func TestUnitDedup(t *testing.T) {
+	t.Run("consecutive duplicates", func(t *testing.T) {
+		input := rheos.FromSlice(context.Background(), []int{1, 1, 2, 2, 2, 1, 3, 3})
+		got, err := rheos.Collect(rheos.Dedup(input))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{1, 2, 1, 3}, got)
+	})
+
+	t.Run("by key", func(t *testing.T) {
+		type reading struct {
+			sensor string
+			value  int
+		}
+		input := rheos.FromSlice(context.Background(), []reading{{"a", 1}, {"a", 2}, {"b", 3}, {"a", 4}})
+		got, err := rheos.Collect(rheos.DedupBy(input, func(r reading) string { return r.sensor }))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []reading{{"a", 1}, {"b", 3}, {"a", 4}}, got)
+	})
+
+	t.Run("stopped by consumer", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.Take(rheos.Dedup(rheos.Repeat(context.Background(), 1)), 1))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{1}, got)
+	})
+}
+
+func TestUnitDistinctWindow(t *testing.T) {
+	t.Run("recent duplicates", func(t *testing.T) {
+		input := rheos.FromSlice(context.Background(), []int{1, 2, 1, 3, 1, 2, 4, 2})
+		got, err := rheos.Collect(rheos.DistinctWindow(input, 2))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// 1 is refreshed by its duplicate, so 3 makes the window forget 2, not 1
+		assertSlicesEqual(t, []int{1, 2, 3, 2, 4}, got)
+	})
+
+	t.Run("window larger than distinct values", func(t *testing.T) {
+		input := rheos.FromSlice(context.Background(), []int{3, 1, 3, 2, 1, 2})
+		got, err := rheos.Collect(rheos.DistinctWindow(input, 10))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{3, 1, 2}, got)
+	})
+
+	t.Run("stopped by consumer", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.Take(rheos.DistinctWindow(rheos.Repeat(context.Background(), 1), 2), 1))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{1}, got)
+	})
+}
+
+func TestUnitDrain(t *testing.T) {
+	t.Run("runs side effects", func(t *testing.T) {
+		var seen int64
+		err := rheos.Drain(rheos.Peek(newProducer(context.Background(), 5), func(context.Context, int) error {
+			atomic.AddInt64(&seen, 1)
+			return nil
+		}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if seen != 5 {
+			t.Errorf("saw %d elements, want 5", seen)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		err := rheos.Drain(rheos.Peek(newProducer(context.Background(), 5), func(context.Context, int) error {
+			return errTest
+		}))
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+}
+
+func TestUnitWithErrorHandler(t *testing.T) {
+	failAt := func(n int) func(context.Context, int) (int, error) {
+		return func(_ context.Context, v int) (int, error) {
+			if v == n {
+				return 0, errTest
+			}
+			return v, nil
+		}
+	}
+
+	t.Run("failing step", func(t *testing.T) {
+		var handled []error
+		mapped := rheos.Map(newProducer(context.Background(), 10), failAt(3), rheos.WithErrorHandler[int](func(err error) {
+			handled = append(handled, err)
+		}))
+		_, err := rheos.Collect(mapped)
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+		if len(handled) != 1 || !errors.Is(handled[0], errTest) {
+			t.Errorf("handled %v, want %v once", handled, errTest)
+		}
+	})
+
+	cancelAt := func(n int, cancel context.CancelFunc) func(context.Context, int) (int, error) {
+		return func(ctx context.Context, v int) (int, error) {
+			if v == n {
+				cancel()
+				return 0, ctx.Err()
+			}
+			return v, nil
+		}
+	}
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var handled []error
+		mapped := rheos.Map(newProducer(ctx, 10), cancelAt(3, cancel), rheos.WithErrorHandler[int](func(err error) {
+			handled = append(handled, err)
+		}))
+		_, err := rheos.Collect(mapped)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+		if len(handled) != 0 {
+			t.Errorf("handled %v, want none for context cancellation", handled)
+		}
+	})
+
+	t.Run("cancel errors", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var handled []error
+		mapped := rheos.Map(
+			newProducer(ctx, 10),
+			cancelAt(3, cancel),
+			rheos.WithErrorHandler[int](func(err error) { handled = append(handled, err) }),
+			rheos.WithCancelErrors[int](),
+		)
+		_, err := rheos.Collect(mapped)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+		if len(handled) != 1 || !errors.Is(handled[0], context.Canceled) {
+			t.Errorf("handled %v, want %v once", handled, context.Canceled)
+		}
+	})
+}
+
+func TestUnitMapError(t *testing.T) {
+	annotate := func(err error) error { return fmt.Errorf("fetch users: %w", err) }
+	failAt := func(n int) func(context.Context, int) (int, error) {
+		return func(_ context.Context, v int) (int, error) {
+			if v == n {
+				return 0, errTest
+			}
+			return v, nil
+		}
+	}
+
+	t.Run("no error", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.MapError(newProducer(context.Background(), 5), annotate))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(5), got)
+	})
+
+	t.Run("transforms preceding error", func(t *testing.T) {
+		failing := rheos.Map(newProducer(context.Background(), 10), failAt(3))
+		_, err := rheos.Collect(rheos.MapError(failing, annotate))
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+		if !strings.Contains(err.Error(), "fetch users: ") {
+			t.Errorf("error %q is not annotated", err)
+		}
+		var pipeErr *rheos.PipelineError
+		if !errors.As(err, &pipeErr) || pipeErr.Step != "Map" {
+			t.Errorf("got %#v, want *rheos.PipelineError of step Map", err)
+		}
+	})
+
+	t.Run("next steps are not transformed", func(t *testing.T) {
+		mapped := rheos.Map(rheos.MapError(newProducer(context.Background(), 10), annotate), failAt(3))
+		_, err := rheos.Collect(mapped)
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+		if strings.Contains(err.Error(), "fetch users: ") {
+			t.Errorf("error %q of the next step is annotated", err)
+		}
+	})
+
+	t.Run("cancellation is not transformed", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := rheos.Collect(rheos.MapError(newProducer(ctx, 10), annotate))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+		if strings.Contains(err.Error(), "fetch users: ") {
+			t.Errorf("cancellation error %q is annotated", err)
+		}
+	})
+
+	t.Run("stopped by consumer", func(t *testing.T) {
+		var produced int64
+		got, err := rheos.Collect(rheos.Take(rheos.MapError(newInfiniteProducer(context.Background(), &produced), annotate), 3))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{0, 1, 2}, got)
+	})
+}
+
+type partialError struct{ reason string }
+
+func (e *partialError) Error() string { return "partial input: " + e.reason }
+
+func TestUnitCatch(t *testing.T) {
+	partial := func(reason string) rheos.Stream[int] {
+		return rheos.FromIter(context.Background(), func(yield func(int) bool) error {
+			for i := 0; i < 3; i++ {
+				if !yield(i) {
+					return nil
+				}
+			}
+			return &partialError{reason: reason}
+		})
+	}
+	skipPartial := func(err error) error {
+		var partialErr *partialError
+		if errors.As(err, &partialErr) {
+			return nil
+		}
+		return fmt.Errorf("not recoverable: %w", err)
+	}
+
+	t.Run("handled error ends stream", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.Catch(partial("truncated"), skipPartial))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !slicesPrefix(got, []int{0, 1, 2}) {
+			t.Errorf("got %v, want prefix of %v", got, []int{0, 1, 2})
+		}
+	})
+
+	t.Run("unhandled error is rewrapped", func(t *testing.T) {
+		failing := rheos.FromIter(context.Background(), func(yield func(int) bool) error {
+			yield(1)
+			return errTest
+		})
+		_, err := rheos.Collect(rheos.Catch(failing, skipPartial))
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+		if !strings.Contains(err.Error(), "not recoverable: ") {
+			t.Errorf("error %q is not rewrapped", err)
+		}
+		var pipeErr *rheos.PipelineError
+		if !errors.As(err, &pipeErr) || pipeErr.Step != "FromIter" {
+			t.Errorf("got %#v, want *rheos.PipelineError of step FromIter", err)
+		}
+	})
+
+	t.Run("next steps continue after handled error", func(t *testing.T) {
+		mapped := rheos.Map(rheos.Catch(partial("truncated"), skipPartial), func(_ context.Context, v int) (int, error) {
+			return v * 10, nil
+		})
+		got, err := rheos.Collect(mapped)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !slicesPrefix(got, []int{0, 10, 20}) {
+			t.Errorf("got %v, want prefix of %v", got, []int{0, 10, 20})
+		}
+	})
+
+	t.Run("next steps are not handled", func(t *testing.T) {
+		var called bool
+		mapped := rheos.Map(rheos.Catch(newProducer(context.Background(), 10), func(error) error {
+			called = true
+			return nil
+		}), func(_ context.Context, v int) (int, error) {
+			if v == 3 {
+				return 0, errTest
+			}
+			return v, nil
+		})
+		_, err := rheos.Collect(mapped)
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+		if called {
+			t.Error("handler is called with the error of the next step")
+		}
+	})
+
+	t.Run("cancellation is never handled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		var called bool
+		_, err := rheos.Collect(rheos.Catch(newProducer(ctx, 10), func(error) error {
+			called = true
+			return nil
+		}))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+		if called {
+			t.Error("handler is called with the cancellation error")
+		}
+	})
+}
+
+// slicesPrefix reports whether got is a prefix of want.
+func slicesPrefix[T comparable](got, want []T) bool {
+	if len(got) > len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestUnitOnComplete(t *testing.T) {
+	// record returns a hook which records its calls and the error.
+	record := func() (func(error), *int, *error) {
+		var (
+			calls int
+			got   error
+		)
+		return func(err error) {
+			calls++
+			got = err
+		}, &calls, &got
+	}
+
+	t.Run("success", func(t *testing.T) {
+		hook, calls, got := record()
+		elems, err := rheos.Collect(rheos.OnComplete(newProducer(context.Background(), 5), hook))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(5), elems)
+		if *calls != 1 || *got != nil {
+			t.Errorf("hook called %d times with %v, want once with nil", *calls, *got)
+		}
+	})
+
+	t.Run("preceding error", func(t *testing.T) {
+		hook, calls, got := record()
+		failing := rheos.Map(newProducer(context.Background(), 10), func(_ context.Context, v int) (int, error) {
+			if v == 3 {
+				return 0, errTest
+			}
+			return v, nil
+		})
+		_, err := rheos.Collect(rheos.OnComplete(failing, hook))
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+		var pipeErr *rheos.PipelineError
+		if !errors.As(err, &pipeErr) || pipeErr.Step != "Map" {
+			t.Errorf("got %#v, want *rheos.PipelineError of step Map", err)
+		}
+		if *calls != 1 || !errors.Is(*got, errTest) || (*got).Error() != errTest.Error() {
+			t.Errorf("hook called %d times with %v, want once with %v", *calls, *got, errTest)
+		}
+	})
+
+	t.Run("stopped by First", func(t *testing.T) {
+		hook, calls, got := record()
+		var produced int64
+		first, ok, err := rheos.First(rheos.OnComplete(newInfiniteProducer(context.Background(), &produced), hook))
+		if err != nil || !ok || first != 0 {
+			t.Fatalf("got %d, %v, %v, want 0, true, nil", first, ok, err)
+		}
+		if *calls != 1 || *got != nil {
+			t.Errorf("hook called %d times with %v, want once with nil", *calls, *got)
+		}
+	})
+
+	t.Run("next step error", func(t *testing.T) {
+		hook, calls, got := record()
+		mapped := rheos.Map(rheos.OnComplete(newProducer(context.Background(), 10), hook), func(_ context.Context, v int) (int, error) {
+			if v == 3 {
+				return 0, errTest
+			}
+			return v, nil
+		})
+		_, err := rheos.Collect(mapped)
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+		if *calls != 1 || !errors.Is(*got, context.Canceled) {
+			t.Errorf("hook called %d times with %v, want once with %v", *calls, *got, context.Canceled)
+		}
+	})
+}
+
+func TestUnitUnsupportedOption(t *testing.T) {
+	t.Run("option of another step", func(t *testing.T) {
+		double := func(_ context.Context, v int) (int, error) { return v * 2, nil }
+		_, err := rheos.Collect(rheos.Map(newProducer(context.Background(), 10), double, rheos.WithWindow[int](3)))
+		if !errors.Is(err, rheos.ErrUnsupportedOption) {
+			t.Fatalf("unexpected error: %v, want: %v", err, rheos.ErrUnsupportedOption)
+		}
+		var pipeErr *rheos.PipelineError
+		if errors.As(err, &pipeErr) && pipeErr.Step != "Map" {
+			t.Errorf("unexpected step: %q, want: Map", pipeErr.Step)
+		}
+	})
+
+	t.Run("source", func(t *testing.T) {
+		_, err := rheos.Collect(rheos.FromSlice(context.Background(), []string{"a"}, rheos.WithMaxLineSize[string](10)))
+		if !errors.Is(err, rheos.ErrUnsupportedOption) {
+			t.Errorf("unexpected error: %v, want: %v", err, rheos.ErrUnsupportedOption)
+		}
+	})
+
+	t.Run("custom option", func(t *testing.T) {
+		buffered := func(o *rheos.Options[int]) { o.Buffer = 3 }
+		got, err := rheos.Collect(rheos.Map(newProducer(context.Background(), 3), func(_ context.Context, v int) (int, error) {
+			return v, nil
+		}, buffered))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(3), got)
+	})
+}
+
+func TestUnitWithDefaultBuffer(t *testing.T) {
+	// processed returns the number of elements mapped while the consumer is blocked on the first element:
+	// the blocked one, the buffered ones and the one waiting to be sent.
+	processed := func(ctx context.Context, ops ...rheos.Option[int]) int64 {
+		var count int64
+		pipe := rheos.Map(newProducer(ctx, 10), func(_ context.Context, v int) (int, error) {
+			atomic.AddInt64(&count, 1)
+			return v, nil
+		}, ops...)
+
+		var got int64
+		first := true
+		err := rheos.ForEach(pipe, func(_ context.Context, _ int) error {
+			if first {
+				first = false
+				time.Sleep(50 * time.Millisecond)
+				got = atomic.LoadInt64(&count)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		return got
+	}
+
+	t.Run("default", func(t *testing.T) {
+		if got := processed(rheos.WithDefaultBuffer(context.Background(), 3)); got != 5 {
+			t.Errorf("got %d processed, want 5", got)
+		}
+	})
+
+	t.Run("unbuffered without default", func(t *testing.T) {
+		if got := processed(context.Background()); got != 2 {
+			t.Errorf("got %d processed, want 2", got)
+		}
+	})
+
+	t.Run("explicit buffer overrides default", func(t *testing.T) {
+		ctx := rheos.WithDefaultBuffer(context.Background(), 3)
+		if got := processed(ctx, rheos.WithBuffer[int](1)); got != 3 {
+			t.Errorf("got %d processed, want 3", got)
+		}
+		if got := processed(ctx, rheos.WithBuffer[int](0)); got != 2 {
+			t.Errorf("got %d processed, want 2", got)
+		}
+	})
+}
+
+func TestUnitBuffered(t *testing.T) {
+	order := make(chan string)
+	num := 5
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		defer close(order)
+
+		p := newProducer(context.Background(), num)
+		buf := rheos.Filter(
+			p,
+			func(ctx context.Context, i int) (bool, error) {
+				order <- "buffered"
+				return true, nil
+			},
+			rheos.WithBuffer[int](num),
+		)
+		unbuf := rheos.Filter(
+			buf,
+			func(ctx context.Context, i int) (bool, error) {
+				time.Sleep(10 * time.Millisecond) // simulate work
+				order <- "unbuffered"
+				return true, nil
+			},
+		)
+		return rheos.ForEach(unbuf, func(_ context.Context, i int) error {
+			return nil
+		})
+	})
+
+	// fast step with buffer can quickly fill the buffer
+	// and then slow step without buffer will start processing
+	wantResult := []string{
+		"buffered",
+		"buffered",
+		"buffered",
+		"buffered",
+		"buffered",
+		"unbuffered",
+		"unbuffered",
+		"unbuffered",
+		"unbuffered",
+		"unbuffered",
+	}
+
+	var result []string
+	for i := range order {
+		result = append(result, i)
+	}
+
+	if err := eg.Wait(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertSlicesEqual(t, wantResult, result)
+}
+
+func TestUnitFromChannel(t *testing.T) {
+	t.Run("collect items", func(t *testing.T) {
+		num := int(rand.Int31n(100) + 10)
+		input := make(chan int, num)
+		go func() {
+			defer close(input)
+			for i := 0; i < num; i++ {
+				input <- i
+			}
+		}()
+
+		p := rheos.FromChannel(context.Background(), input)
+		got, err := rheos.Collect(p)
+
+		want := intRange(num)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, want, got)
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		num := int(rand.Int31n(100) + 10)
+		input := make(chan int, num)
+		go func() {
+			defer close(input)
+			for i := 0; i < num; i++ {
+				if i >= num/2 {
+					cancel()
+					return
+				}
+
+				input <- i
+			}
+		}()
+
+		p := rheos.FromChannel(ctx, input)
+		_, err := rheos.Collect(p)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestUnitFromChannel2(t *testing.T) {
+	t.Run("collect values", func(t *testing.T) {
+		num := int(rand.Int31n(100) + 10)
+		input := make(chan rheos.Result[int])
+		go func() {
+			defer close(input)
+			for i := 0; i < num; i++ {
+				input <- rheos.Result[int]{Value: i}
+			}
+		}()
+
+		got, err := rheos.Collect(rheos.FromChannel2(context.Background(), input))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(num), got)
+	})
+
+	t.Run("result error", func(t *testing.T) {
+		input := make(chan rheos.Result[int], 4)
+		input <- rheos.Result[int]{Value: 1}
+		input <- rheos.Result[int]{Value: 2, Err: errTest}
+		input <- rheos.Result[int]{Value: 3}
+		close(input)
+
+		var got []int
+		err := rheos.ForEach(rheos.FromChannel2(context.Background(), input), func(_ context.Context, v int) error {
+			got = append(got, v)
+			return nil
+		})
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+		if len(got) > 1 {
+			t.Errorf("got %v, want at most the value before the error", got)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		input := make(chan rheos.Result[int]) // never closed
+		_, err := rheos.Collect(rheos.FromChannel2(ctx, input))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestUnitWithCancel(t *testing.T) {
+	t.Run("cancel stops pipeline", func(t *testing.T) {
+		infinite := rheos.FromIter(context.Background(), func(yield func(int) bool) error {
+			for i := 0; yield(i); i++ {
+			}
+			return nil
+		})
+		p, cancel := rheos.WithCancel(infinite)
+		mapped := rheos.Map(p, func(_ context.Context, v int) (int, error) {
+			if v == 10 {
+				go cancel()
+			}
+			return v, nil
+		})
+
+		_, err := rheos.Collect(mapped)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+
+	t.Run("passes without cancel", func(t *testing.T) {
+		num := int(rand.Int31n(100) + 10)
+		p, cancel := rheos.WithCancel(newProducer(context.Background(), num))
+		defer cancel()
+
+		got, err := rheos.Collect(p)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(num), got)
+	})
+}
+
+func TestUnitWithContext(t *testing.T) {
+	type key struct{}
+
+	t.Run("deadline stops pipeline", func(t *testing.T) {
+		var produced int64
+		pipe := newInfiniteProducer(context.Background(), &produced)
+		ctx, cancel := context.WithTimeout(pipe.Context(), 10*time.Millisecond)
+		defer cancel()
+
+		err := rheos.Drain(rheos.WithContext(pipe, ctx))
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.DeadlineExceeded)
+		}
+	})
+
+	t.Run("values for subsequent steps", func(t *testing.T) {
+		src := rheos.FromSlice(context.WithValue(context.Background(), key{}, "source"), []int{1, 2, 3})
+		before := rheos.Map(src, func(ctx context.Context, v int) (int, error) {
+			if got := ctx.Value(key{}); got != "source" {
+				t.Errorf("got value %v before WithContext, want source", got)
+			}
+			return v, nil
+		})
+		after := rheos.Map(rheos.WithContext(before, context.WithValue(before.Context(), key{}, "step")), func(ctx context.Context, v int) (int, error) {
+			if got := ctx.Value(key{}); got != "step" {
+				t.Errorf("got value %v after WithContext, want step", got)
+			}
+			return v, nil
+		})
+
+		got, err := rheos.Collect(after)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{1, 2, 3}, got)
+	})
+
+	t.Run("unrelated context is cancelled with pipeline", func(t *testing.T) {
+		type other struct{}
+		src := rheos.FromSlice(context.WithValue(context.Background(), key{}, "source"), []int{1, 2, 3})
+		failing := rheos.Map(src, func(_ context.Context, v int) (int, error) {
+			if v == 2 {
+				return 0, errTest
+			}
+			return v, nil
+		})
+
+		var stepCtx context.Context
+		err := rheos.ForEach(rheos.WithContext(failing, context.WithValue(context.Background(), other{}, true)), func(ctx context.Context, _ int) error {
+			if ctx.Value(key{}) != "source" || ctx.Value(other{}) != true {
+				t.Error("want values of both contexts")
+			}
+			stepCtx = ctx
+			return nil
+		})
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+		if stepCtx == nil {
+			return // the pipeline failed before the first element
+		}
+		select {
+		case <-stepCtx.Done():
+		case <-time.After(time.Second):
+			t.Error("context is not cancelled with pipeline")
+		}
+	})
+}
+
+func TestUnitClose(t *testing.T) {
+	t.Run("stops pipeline", func(t *testing.T) {
+		var produced int64
+		mapped := rheos.Map(newInfiniteProducer(context.Background(), &produced), func(_ context.Context, v int) (int, error) {
+			return v, nil
+		}, rheos.WithBuffer[int](10))
+
+		if err := mapped.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		p := atomic.LoadInt64(&produced)
+		time.Sleep(10 * time.Millisecond)
+		if got := atomic.LoadInt64(&produced); got != p {
+			t.Errorf("produced %d elements after Close, want none", got-p)
+		}
+	})
+
+	t.Run("pipeline error", func(t *testing.T) {
+		mapped := rheos.Map(newProducer(context.Background(), 10), func(context.Context, int) (int, error) {
+			return 0, errTest
+		})
+
+		if err := mapped.Close(); !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+}
+
+func TestUnitFlatMap(t *testing.T) {
+	t.Run("expands elements", func(t *testing.T) {
+		p := rheos.FromSlice(context.Background(), []string{"a b", "", "c d e"})
+		got, err := rheos.Collect(rheos.FlatMap(p, func(_ context.Context, line string) ([]string, error) {
+			return strings.Fields(line), nil
+		}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []string{"a", "b", "c", "d", "e"}, got)
+	})
+
+	t.Run("mapper error", func(t *testing.T) {
+		_, err := rheos.Collect(rheos.FlatMap(newProducer(context.Background(), 5), func(_ context.Context, v int) ([]int, error) {
+			if v == 3 {
+				return nil, errTest
+			}
+			return []int{v, v}, nil
+		}))
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := rheos.Collect(rheos.FlatMap(newProducer(ctx, 5), func(_ context.Context, v int) ([]int, error) {
+			return []int{v}, nil
+		}))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestUnitScan(t *testing.T) {
+	sum := func(acc, v int) (int, error) { return acc + v, nil }
+
+	t.Run("running total", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.Scan(newProducer(context.Background(), 5), sum, 10))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{10, 11, 13, 16, 20}, got)
+	})
+
+	t.Run("empty stream", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.Scan(newProducer(context.Background(), 0), sum, 10))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != 0 {
+			t.Errorf("want empty result, got %v", got)
+		}
+	})
+
+	t.Run("accumulator error", func(t *testing.T) {
+		_, err := rheos.Collect(rheos.Scan(newProducer(context.Background(), 5), func(acc, v int) (int, error) {
+			if v == 3 {
+				return 0, errTest
+			}
+			return acc + v, nil
+		}, 0))
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+}
+
+func TestUnitGroupBy(t *testing.T) {
+	t.Run("groups in order of first key", func(t *testing.T) {
+		words := rheos.FromSlice(context.Background(), []string{"bb", "a", "cc", "d", "eee"})
+		groups, err := rheos.Collect(rheos.GroupBy(words, func(_ context.Context, w string) (int, error) {
+			return len(w), nil
+		}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		want := []rheos.Group[int, string]{
+			{Key: 2, Items: []string{"bb", "cc"}},
+			{Key: 1, Items: []string{"a", "d"}},
+			{Key: 3, Items: []string{"eee"}},
+		}
+		if len(groups) != len(want) {
+			t.Fatalf("got %d groups, want %d", len(groups), len(want))
+		}
+		for i := range want {
+			if groups[i].Key != want[i].Key {
+				t.Errorf("group %d: key %d, want %d", i, groups[i].Key, want[i].Key)
+			}
+			assertSlicesEqual(t, want[i].Items, groups[i].Items)
+		}
+	})
+
+	t.Run("key error", func(t *testing.T) {
+		_, err := rheos.Collect(rheos.GroupBy(newProducer(context.Background(), 10), func(_ context.Context, v int) (int, error) {
+			if v == 5 {
+				return 0, errTest
+			}
+			return v % 2, nil
+		}))
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := rheos.Collect(rheos.GroupBy(newProducer(ctx, 10), func(_ context.Context, v int) (int, error) {
+			return v % 2, nil
+		}))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestUnitTake(t *testing.T) {
+	t.Run("takes first elements", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.Take(newProducer(context.Background(), 10), 3))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{0, 1, 2}, got)
+	})
+
+	t.Run("shorter stream", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.Take(newProducer(context.Background(), 3), 10))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{0, 1, 2}, got)
+	})
+
+	t.Run("stops infinite stream", func(t *testing.T) {
+		var produced int64
+		mapped := rheos.Map(newInfiniteProducer(context.Background(), &produced), func(_ context.Context, v int) (int, error) {
+			return v * 2, nil
+		})
+		got, err := rheos.Collect(rheos.Take(mapped, 5))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{0, 2, 4, 6, 8}, got)
+		if p := atomic.LoadInt64(&produced); p > 10 {
+			t.Errorf("produced %d elements, want source stopped after around 5", p)
+		}
+	})
+
+	t.Run("stops filtering steps", func(t *testing.T) {
+		var produced int64
+		filtered := rheos.Filter(newInfiniteProducer(context.Background(), &produced), func(_ context.Context, v int) (bool, error) {
+			return v == 5, nil // no more matches after the first one
+		})
+		got, err := rheos.Collect(rheos.Take(filtered, 1))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{5}, got)
+	})
+
+	t.Run("stops grouping", func(t *testing.T) {
+		var produced int64
+		grouped := rheos.GroupBy(newInfiniteProducer(context.Background(), &produced), func(_ context.Context, v int) (int, error) {
+			return v % 2, nil
+		})
+		got, err := rheos.Collect(rheos.Take(grouped, 0))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != 0 {
+			t.Errorf("got %d groups, want none", len(got))
+		}
+	})
+
+	for _, n := range []int{0, -1} {
+		n := n
+		t.Run("not positive "+strconv.Itoa(n), func(t *testing.T) {
+			var produced int64
+			got, err := rheos.Collect(rheos.Take(newInfiniteProducer(context.Background(), &produced), n))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(got) != 0 {
+				t.Errorf("want empty result, got %v", got)
+			}
+			if p := atomic.LoadInt64(&produced); p > 1 {
+				t.Errorf("produced %d elements, want none", p)
+			}
+		})
+	}
+
+	t.Run("stops parallel steps", func(t *testing.T) {
+		var produced int64
+		mapped := rheos.ParMap(newInfiniteProducer(context.Background(), &produced), 4, func(_ context.Context, v int) (int, error) {
+			return v, nil
+		})
+		got, err := rheos.Collect(rheos.Take(mapped, 5))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != 5 {
+			t.Errorf("got %d elements, want 5", len(got))
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := rheos.Collect(rheos.Take(newProducer(ctx, 10), 5))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestUnitTakeWhile(t *testing.T) {
+	lessThan := func(n int) func(context.Context, int) (bool, error) {
+		return func(_ context.Context, v int) (bool, error) {
+			return v < n, nil
+		}
+	}
+
+	t.Run("takes prefix", func(t *testing.T) {
+		var produced int64
+		mapped := rheos.Map(rheos.TakeWhile(newInfiniteProducer(context.Background(), &produced), lessThan(5)), func(_ context.Context, v int) (int, error) {
+			return v * 2, nil
+		})
+		got, err := rheos.Collect(mapped)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{0, 2, 4, 6, 8}, got)
+		if p := atomic.LoadInt64(&produced); p > 10 {
+			t.Errorf("produced %d elements, want source stopped after around 6", p)
+		}
+	})
+
+	t.Run("all match", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.TakeWhile(newProducer(context.Background(), 5), lessThan(10)))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(5), got)
+	})
+
+	t.Run("predicate error", func(t *testing.T) {
+		_, err := rheos.Collect(rheos.TakeWhile(newProducer(context.Background(), 5), func(_ context.Context, v int) (bool, error) {
+			if v == 3 {
+				return false, errTest
+			}
+			return true, nil
+		}))
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+}
+
+func TestUnitSkip(t *testing.T) {
+	t.Run("skips first elements", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.Skip(newProducer(context.Background(), 5), 3))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{3, 4}, got)
+	})
+
+	t.Run("shorter stream", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.Skip(newProducer(context.Background(), 3), 10))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != 0 {
+			t.Errorf("want empty result, got %v", got)
+		}
+	})
+
+	t.Run("not positive", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.Skip(newProducer(context.Background(), 3), -1))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(3), got)
+	})
+
+	t.Run("context cancelled while skipping", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var produced int64
+		src := rheos.Map(newInfiniteProducer(ctx, &produced), func(_ context.Context, v int) (int, error) {
+			if v == 10 {
+				cancel()
+			}
+			return v, nil
+		})
+
+		_, err := rheos.Collect(rheos.Skip(src, 1000))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestUnitSkipWhile(t *testing.T) {
+	t.Run("skips prefix", func(t *testing.T) {
+		calls := 0
+		p := rheos.FromSlice(context.Background(), []int{1, 2, 5, 1, 6})
+		got, err := rheos.Collect(rheos.SkipWhile(p, func(_ context.Context, v int) (bool, error) {
+			calls++
+			return v < 3, nil
+		}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{5, 1, 6}, got)
+		if calls != 3 {
+			t.Errorf("predicate called %d times, want 3", calls)
+		}
+	})
+
+	t.Run("all skipped", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.SkipWhile(newProducer(context.Background(), 5), func(context.Context, int) (bool, error) {
+			return true, nil
+		}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != 0 {
+			t.Errorf("want empty result, got %v", got)
+		}
+	})
+
+	t.Run("predicate error", func(t *testing.T) {
+		_, err := rheos.Collect(rheos.SkipWhile(newProducer(context.Background(), 5), func(context.Context, int) (bool, error) {
+			return false, errTest
+		}))
+		if !errors.Is(err, errTest) {
+			t.Errorf("unexpected error: %v, want: %v", err, errTest)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := rheos.Collect(rheos.SkipWhile(newProducer(ctx, 5), func(context.Context, int) (bool, error) {
+			return true, nil
+		}))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func newProducer(ctx context.Context, num int) rheos.Stream[int] {
+	return rheos.FromIter(ctx, func(yield func(v int) bool) error {
+		for i := 0; i < num; i++ {
+			if !yield(i) {
+				break
+			}
+		}
+
+		return nil
+	})
+}
+
+// newInfiniteProducer produces integers until it is stopped, counting produced elements.
+func newInfiniteProducer(ctx context.Context, produced *int64) rheos.Stream[int] {
+	return rheos.FromIter(ctx, func(yield func(v int) bool) error {
+		for i := 0; yield(i); i++ {
+			atomic.AddInt64(produced, 1)
 		}
 
 		return nil
@@ -0,0 +1,204 @@
+package rheos
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+)
+
+// ErrInvalidQuantile is returned when a requested quantile is outside of (0, 1) range.
+var ErrInvalidQuantile = errors.New("quantile must be in range (0, 1)")
+
+// Number is a constraint that permits any integer or floating-point type.
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64
+}
+
+// Sum consumes the stream and returns the sum of its elements, zero for the empty stream.
+// Integer sums wrap around on overflow, as usual in Go.
+// If context is cancelled during processing, Sum stops and returns the sum of the elements so far
+// and *PipelineError.
+func Sum[I Number](pipe Stream[I]) (I, error) {
+	return Reduce(pipe, func(acc I, elem I) (I, error) { return acc + elem, nil }, 0)
+}
+
+// Average consumes the stream and returns the arithmetic mean of its elements and true.
+// It returns zero and false for the empty stream. Elements are summed as float64, so integer elements do not overflow,
+// but very large integers lose precision.
+// If context is cancelled during processing, Average stops and returns error.
+func Average[I Number](pipe Stream[I]) (float64, bool, error) {
+	type total struct {
+		sum   float64
+		count int
+	}
+
+	result, err := Reduce(pipe, func(acc total, elem I) (total, error) {
+		return total{sum: acc.sum + float64(elem), count: acc.count + 1}, nil
+	}, total{})
+	if err != nil || result.count == 0 {
+		return 0, false, err
+	}
+
+	return result.sum / float64(result.count), true, nil
+}
+
+// ApproxQuantile estimates given quantiles of the stream elements without storing them,
+// using P² (piecewise-parabolic) algorithm by Jain and Chlamtac.
+// It keeps only 5 markers per quantile, so memory usage does not depend on the size of the stream.
+// Quantiles must be in range (0, 1), e.g. 0.5 for a median or 0.99 for p99.
+//
+// The result is an estimate. P² gives no strict error bounds, but for smooth distributions
+// the error is usually within a few percent of the true value after a few hundreds of elements.
+// It is less accurate for small streams, strongly skewed distributions or distributions changing over time.
+// For streams with up to 5 elements exact quantile (nearest rank) is returned.
+// For integer types the estimate is truncated.
+// The result is empty for the empty stream.
+// If any of quantiles is out of range, ApproxQuantile stops the stream without processing it and returns ErrInvalidQuantile.
+// If context is cancelled during processing, ApproxQuantile stops and returns error.
+func ApproxQuantile[I Number](pipe Stream[I], quantiles []float64) (map[float64]I, error) {
+	estimators := make([]*p2Estimator, len(quantiles))
+	for i, q := range quantiles {
+		if q <= 0 || q >= 1 || math.IsNaN(q) {
+			_ = pipe.Close() // the error of the pipeline is irrelevant, as the quantiles are invalid anyway
+			return nil, ErrInvalidQuantile
+		}
+		estimators[i] = newP2Estimator(q)
+	}
+
+	count := 0
+	err := ForEach(pipe, func(_ context.Context, elem I) error {
+		count++
+		for _, est := range estimators {
+			est.add(float64(elem))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[float64]I, len(quantiles))
+	if count == 0 {
+		return result, nil
+	}
+	for i, q := range quantiles {
+		result[q] = I(estimators[i].quantile())
+	}
+
+	return result, nil
+}
+
+// RunningDistinctCount emits the number of distinct elements seen so far for each element of the stream.
+// It remembers every distinct element, so memory grows with the number of distinct elements.
+// If context is cancelled during processing, RunningDistinctCount stops processing and returns error.
+func RunningDistinctCount[I comparable](pipe Stream[I], ops ...Option[int]) Stream[int] {
+	seen := make(map[I]struct{})
+
+	return Map[I, int](
+		pipe,
+		func(_ context.Context, elem I) (int, error) {
+			seen[elem] = struct{}{}
+
+			return len(seen), nil
+		},
+		ops...,
+	)
+}
+
+// p2Estimator estimates a single quantile with P² algorithm.
+// See "The P² algorithm for dynamic calculation of quantiles and histograms without storing observations"
+// by Raj Jain and Imrich Chlamtac.
+type p2Estimator struct {
+	p       float64
+	count   int
+	heights [5]float64 // marker heights
+	pos     [5]float64 // actual marker positions
+	desired [5]float64 // desired marker positions
+	incr    [5]float64 // increments of desired positions
+}
+
+func newP2Estimator(p float64) *p2Estimator {
+	return &p2Estimator{
+		p:       p,
+		pos:     [5]float64{1, 2, 3, 4, 5},
+		desired: [5]float64{1, 1 + 2*p, 1 + 4*p, 3 + 2*p, 5},
+		incr:    [5]float64{0, p / 2, p, (1 + p) / 2, 1},
+	}
+}
+
+func (e *p2Estimator) add(x float64) {
+	if e.count < len(e.heights) {
+		e.heights[e.count] = x
+		e.count++
+		if e.count == len(e.heights) {
+			sort.Float64s(e.heights[:])
+		}
+
+		return
+	}
+	e.count++
+
+	var cell int
+	switch {
+	case x < e.heights[0]:
+		e.heights[0] = x
+		cell = 0
+	case x >= e.heights[4]:
+		e.heights[4] = x
+		cell = 3
+	default:
+		for cell = 0; cell < 3; cell++ {
+			if x < e.heights[cell+1] {
+				break
+			}
+		}
+	}
+
+	for i := cell + 1; i < len(e.pos); i++ {
+		e.pos[i]++
+	}
+	for i := range e.desired {
+		e.desired[i] += e.incr[i]
+	}
+
+	for i := 1; i < 4; i++ {
+		diff := e.desired[i] - e.pos[i]
+		if (diff >= 1 && e.pos[i+1]-e.pos[i] > 1) || (diff <= -1 && e.pos[i-1]-e.pos[i] < -1) {
+			sign := math.Copysign(1, diff)
+			height := e.parabolic(i, sign)
+			if e.heights[i-1] < height && height < e.heights[i+1] {
+				e.heights[i] = height
+			} else {
+				e.heights[i] = e.linear(i, sign)
+			}
+			e.pos[i] += sign
+		}
+	}
+}
+
+func (e *p2Estimator) parabolic(i int, sign float64) float64 {
+	return e.heights[i] + sign/(e.pos[i+1]-e.pos[i-1])*
+		((e.pos[i]-e.pos[i-1]+sign)*(e.heights[i+1]-e.heights[i])/(e.pos[i+1]-e.pos[i])+
+			(e.pos[i+1]-e.pos[i]-sign)*(e.heights[i]-e.heights[i-1])/(e.pos[i]-e.pos[i-1]))
+}
+
+func (e *p2Estimator) linear(i int, sign float64) float64 {
+	next := i + int(sign)
+
+	return e.heights[i] + sign*(e.heights[next]-e.heights[i])/(e.pos[next]-e.pos[i])
+}
+
+func (e *p2Estimator) quantile() float64 {
+	if e.count > len(e.heights) {
+		return e.heights[2]
+	}
+
+	observed := append([]float64(nil), e.heights[:e.count]...)
+	sort.Float64s(observed)
+
+	return observed[int(e.p*float64(len(observed)-1)+0.5)]
+}
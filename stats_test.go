@@ -0,0 +1,203 @@
+package rheos_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/dmksnnk/rheos"
+)
+
+func TestSum(t *testing.T) {
+	t.Run("integers", func(t *testing.T) {
+		got, err := rheos.Sum(newProducer(context.Background(), 10))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != 45 {
+			t.Errorf("got %d, want 45", got)
+		}
+	})
+
+	t.Run("floats", func(t *testing.T) {
+		got, err := rheos.Sum(rheos.FromSlice(context.Background(), []float64{0.5, 1.25, -0.75}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != 1 {
+			t.Errorf("got %v, want 1", got)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		got, err := rheos.Sum(rheos.FromSlice(context.Background(), []uint8{}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != 0 {
+			t.Errorf("got %d, want 0", got)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := rheos.Sum(newProducer(ctx, 10))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestAverage(t *testing.T) {
+	t.Run("integers", func(t *testing.T) {
+		got, ok, err := rheos.Average(rheos.FromSlice(context.Background(), []int8{100, 100, 101}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !ok || math.Abs(got-301.0/3) > 1e-9 {
+			t.Errorf("got %v, %v, want %v, true", got, ok, 301.0/3)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		got, ok, err := rheos.Average(rheos.FromSlice(context.Background(), []float64{}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ok || got != 0 {
+			t.Errorf("got %v, %v, want 0, false", got, ok)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, ok, err := rheos.Average(newProducer(ctx, 10))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+		if ok {
+			t.Error("want no average on error")
+		}
+	})
+}
+
+func TestApproxQuantile(t *testing.T) {
+	t.Run("uniform distribution", func(t *testing.T) {
+		num := 10000
+		vals := rand.Perm(num)
+		quantiles := []float64{0.5, 0.9, 0.99}
+
+		p := rheos.FromSlice(context.Background(), vals)
+		got, err := rheos.ApproxQuantile(p, quantiles)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		for _, q := range quantiles {
+			want := q * float64(num)
+			if math.Abs(float64(got[q])-want) > 0.02*float64(num) {
+				t.Errorf("quantile %v: got %v, want around %v", q, got[q], want)
+			}
+		}
+	})
+
+	t.Run("small stream", func(t *testing.T) {
+		p := rheos.FromSlice(context.Background(), []float64{3, 1, 2})
+		got, err := rheos.ApproxQuantile(p, []float64{0.5})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got[0.5] != 2 {
+			t.Errorf("got median %v, want 2", got[0.5])
+		}
+	})
+
+	t.Run("five elements", func(t *testing.T) {
+		p := rheos.FromSlice(context.Background(), []int{4, 2, 5, 1, 3})
+		got, err := rheos.ApproxQuantile(p, []float64{0.01, 0.5, 0.99})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := map[float64]int{0.01: 1, 0.5: 3, 0.99: 5}
+		for q, w := range want {
+			if got[q] != w {
+				t.Errorf("quantile %v: got %v, want %v", q, got[q], w)
+			}
+		}
+	})
+
+	t.Run("empty stream", func(t *testing.T) {
+		p := rheos.FromSlice(context.Background(), []int{})
+		got, err := rheos.ApproxQuantile(p, []float64{0.5})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != 0 {
+			t.Errorf("want empty result, got %v", got)
+		}
+	})
+
+	t.Run("invalid quantile", func(t *testing.T) {
+		p := newProducer(context.Background(), 10)
+		_, err := rheos.ApproxQuantile(p, []float64{0.5, 1})
+		if !errors.Is(err, rheos.ErrInvalidQuantile) {
+			t.Errorf("unexpected error: %v, want: %v", err, rheos.ErrInvalidQuantile)
+		}
+		var pipeErr *rheos.PipelineError
+		if errors.As(err, &pipeErr) {
+			t.Errorf("unexpected error: %v, want not PipelineError", err)
+		}
+	})
+
+	t.Run("invalid quantile of empty stream", func(t *testing.T) {
+		_, err := rheos.ApproxQuantile(newProducer(context.Background(), 0), []float64{0})
+		if !errors.Is(err, rheos.ErrInvalidQuantile) {
+			t.Errorf("unexpected error: %v, want: %v", err, rheos.ErrInvalidQuantile)
+		}
+	})
+
+	t.Run("invalid quantile stops infinite stream", func(t *testing.T) {
+		var produced int64
+		_, err := rheos.ApproxQuantile(newInfiniteProducer(context.Background(), &produced), []float64{1.5})
+		if !errors.Is(err, rheos.ErrInvalidQuantile) {
+			t.Errorf("unexpected error: %v, want: %v", err, rheos.ErrInvalidQuantile)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := rheos.ApproxQuantile(newProducer(ctx, 10), []float64{0.5})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestRunningDistinctCount(t *testing.T) {
+	t.Run("counts distinct", func(t *testing.T) {
+		p := rheos.FromSlice(context.Background(), []string{"a", "b", "a", "c", "b", "d"})
+		got, err := rheos.Collect(rheos.RunningDistinctCount(p))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{1, 2, 2, 3, 3, 4}, got)
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := rheos.Collect(rheos.RunningDistinctCount(newProducer(ctx, 10)))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
@@ -0,0 +1,513 @@
+package rheos
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ErrStalled is returned by Watchdog when no elements flow through the stream for too long.
+var ErrStalled = errors.New("stream stalled")
+
+// ErrRateLimit is returned by RateLimit when the limiter never allows an element.
+var ErrRateLimit = errors.New("rate limiter does not allow any element")
+
+// ErrInvalidWindow is returned by Rate when the window is not positive.
+var ErrInvalidWindow = errors.New("rate window must be positive")
+
+// Bucket is a group of elements which timestamps fall into the same time bucket.
+type Bucket[I any] struct {
+	// Start is the beginning of the bucket.
+	Start time.Time
+	// Values are the elements of the bucket in order of arrival.
+	Values []I
+}
+
+// TimeBucket groups consecutive elements into buckets of given duration based on their timestamps.
+// Buckets are aligned to the multiples of bucket since the zero time (see time.Time.Truncate),
+// e.g. for a minute bucket all elements from 12:01:00 to 12:01:59.999 fall into the same bucket.
+// The bucket is emitted when an element from another bucket arrives, and the last bucket is emitted
+// when the stream ends. TimeBucket expects elements ordered by time: an out-of-order element
+// closes the current bucket and starts a new one, so buckets with the same start may be emitted more than once.
+// If context is cancelled during processing, TimeBucket stops processing and returns error.
+func TimeBucket[I any](pipe Stream[I], timestamp func(I) time.Time, bucket time.Duration, ops ...Option[Bucket[I]]) Stream[Bucket[I]] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, "TimeBucket", applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		var current Bucket[I]
+		for elem := range pipe.in {
+			start := timestamp(elem).Truncate(bucket)
+			if len(current.Values) > 0 && !start.Equal(current.Start) {
+				if err := emit(pipe.ctx, stopper, output, current); err != nil {
+					return err
+				}
+				current = Bucket[I]{}
+			}
+
+			current.Start = start
+			current.Values = append(current.Values, elem)
+		}
+
+		if len(current.Values) > 0 {
+			return emit(pipe.ctx, stopper, output, current)
+		}
+
+		return nil
+	})
+
+	return Stream[Bucket[I]]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// Schedule holds elements until their scheduled time and emits each of them when the time arrives.
+// Elements scheduled at the same time are emitted in order of arrival,
+// elements scheduled in the past are emitted immediately.
+// All pending elements are held in memory, the stream ends when the input ends and all pending elements are emitted.
+// If context is cancelled during processing, Schedule stops processing and returns error.
+func Schedule[I any](pipe Stream[I], at func(I) time.Time, ops ...Option[I]) Stream[I] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, "Schedule", applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		var (
+			queue scheduleQueue[I]
+			seq   int
+		)
+		input := pipe.in
+		for input != nil || queue.Len() > 0 {
+			var (
+				timer  *time.Timer
+				timerC <-chan time.Time
+			)
+			if queue.Len() > 0 {
+				wait := time.Until(queue[0].at)
+				if wait <= 0 {
+					next := heap.Pop(&queue).(scheduled[I])
+					if err := emit(pipe.ctx, stopper, output, next.elem); err != nil {
+						return err
+					}
+
+					continue
+				}
+
+				timer = time.NewTimer(wait)
+				timerC = timer.C
+			}
+
+			select {
+			case <-pipe.ctx.Done():
+				stopTimer(timer)
+				return pipe.ctx.Err()
+			case elem, ok := <-input:
+				if !ok {
+					input = nil
+				} else {
+					heap.Push(&queue, scheduled[I]{elem: elem, at: at(elem), seq: seq})
+					seq++
+				}
+			case <-timerC:
+			}
+			stopTimer(timer)
+		}
+
+		return nil
+	})
+
+	return Stream[I]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// ReplayTimed emits elements with the same gaps between them as between their timestamps, scaled by speed:
+// speed of 2 replays twice as fast, 0.5 twice as slow. The first element is emitted immediately.
+// Timing is calculated relative to the first element, so the delays of slow consumers do not accumulate.
+// Elements with timestamps earlier than or equal to the previous ones are emitted immediately.
+// If speed is not positive, elements are emitted without delays.
+// If context is cancelled during processing, ReplayTimed stops processing and returns error.
+func ReplayTimed[I any](pipe Stream[I], timestamp func(I) time.Time, speed float64, ops ...Option[I]) Stream[I] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, "ReplayTimed", applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		var (
+			first   time.Time
+			started time.Time
+		)
+		for elem := range pipe.in {
+			ts := timestamp(elem)
+			if started.IsZero() {
+				first, started = ts, time.Now()
+			} else if speed > 0 {
+				at := started.Add(time.Duration(float64(ts.Sub(first)) / speed))
+				if err := sleep(pipe.ctx, time.Until(at)); err != nil {
+					return err
+				}
+			}
+
+			if err := emit(pipe.ctx, stopper, output, elem); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return Stream[I]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// Watchdog passes elements through, but fails the stream with ErrStalled
+// if no element arrives from the previous step for idle duration, which indicates a hang upstream.
+// Time spent waiting for the next step to receive the element is not counted.
+// If context is cancelled during processing, Watchdog stops processing and returns error.
+func Watchdog[I any](pipe Stream[I], idle time.Duration, ops ...Option[I]) Stream[I] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, "Watchdog", applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		for {
+			timer := time.NewTimer(idle)
+			select {
+			case <-pipe.ctx.Done():
+				timer.Stop()
+				return pipe.ctx.Err()
+			case <-timer.C:
+				return fmt.Errorf("%w: no elements for %s", ErrStalled, idle)
+			case elem, ok := <-pipe.in:
+				timer.Stop()
+				if !ok {
+					return nil
+				}
+
+				if err := emit(pipe.ctx, stopper, output, elem); err != nil {
+					return err
+				}
+			}
+		}
+	})
+
+	return Stream[I]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// Rate passes elements through unchanged, reporting the rate of elements per second over the sliding window.
+// The window is divided into 10 slots, the rate is reported at the end of each slot, so report is called
+// 10 times per window, not for each element. Until the first window has passed, the rate is calculated
+// over the elapsed time. Report is called from the step goroutine, so slow report slows down the stream.
+// If window is not positive, Rate stops processing and returns ErrInvalidWindow.
+// If context is cancelled during processing, Rate stops processing and returns error.
+func Rate[I any](pipe Stream[I], window time.Duration, report func(perSec float64), ops ...Option[I]) Stream[I] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, "Rate", applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		if window <= 0 {
+			return ErrInvalidWindow
+		}
+
+		const numSlots = 10
+		slot := window / numSlots
+		if slot <= 0 {
+			slot = window
+		}
+		ticker := time.NewTicker(slot)
+		defer ticker.Stop()
+
+		var (
+			slots   [numSlots]int
+			current int
+			filled  int
+		)
+		for {
+			select {
+			case <-pipe.ctx.Done():
+				return pipe.ctx.Err()
+			case <-ticker.C:
+				if filled < numSlots {
+					filled++
+				}
+				total := 0
+				for _, count := range slots {
+					total += count
+				}
+				report(float64(total) / (time.Duration(filled) * slot).Seconds())
+
+				current = (current + 1) % numSlots
+				slots[current] = 0
+			case elem, ok := <-pipe.in:
+				if !ok {
+					return nil
+				}
+				slots[current]++
+
+				if err := emit(pipe.ctx, stopper, output, elem); err != nil {
+					return err
+				}
+			}
+		}
+	})
+
+	return Stream[I]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// RateLimit passes elements through, waiting for limiter before each element, so the next steps
+// receive elements no faster than the limiter allows. The limiter can be shared with other pipelines.
+// If limiter can't ever allow an element, because its burst is zero, RateLimit stops processing and returns ErrRateLimit.
+// If context is cancelled during processing, RateLimit stops processing and returns error.
+func RateLimit[I any](pipe Stream[I], limiter *rate.Limiter, ops ...Option[I]) Stream[I] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, "RateLimit", applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		for elem := range pipe.in {
+			reservation := limiter.Reserve()
+			if !reservation.OK() {
+				return ErrRateLimit
+			}
+			if err := sleep(pipe.ctx, reservation.Delay()); err != nil {
+				reservation.Cancel() // give the token back to other users of the limiter
+				return err
+			}
+
+			if err := emit(pipe.ctx, stopper, output, elem); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return Stream[I]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// RateLimitEvery is like RateLimit, but with its own limiter, which allows one element per interval
+// and bursts of up to burst elements.
+func RateLimitEvery[I any](pipe Stream[I], interval time.Duration, burst int, ops ...Option[I]) Stream[I] {
+	return RateLimit(pipe, rate.NewLimiter(rate.Every(interval), burst), ops...)
+}
+
+// Throttle passes elements through, keeping at least minInterval between the elements received by the next step.
+// It does not add delays if elements arrive slower than that. The first element is emitted immediately.
+// If context is cancelled during processing, Throttle stops processing and returns error.
+func Throttle[I any](pipe Stream[I], minInterval time.Duration, ops ...Option[I]) Stream[I] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, "Throttle", applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		var last time.Time
+		for elem := range pipe.in {
+			if !last.IsZero() {
+				if err := sleep(pipe.ctx, minInterval-time.Since(last)); err != nil {
+					return err
+				}
+			}
+
+			if err := emit(pipe.ctx, stopper, output, elem); err != nil {
+				return err
+			}
+			last = time.Now()
+		}
+
+		return nil
+	})
+
+	return Stream[I]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// Debounce emits an element only after no other element arrives for quiet duration,
+// so each burst of elements is collapsed into the last element of the burst.
+// The pending element is emitted immediately when the input ends.
+// If context is cancelled during processing, Debounce stops processing and returns error.
+func Debounce[I any](pipe Stream[I], quiet time.Duration, ops ...Option[I]) Stream[I] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, "Debounce", applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		var (
+			pending I
+			timer   *time.Timer
+			timerC  <-chan time.Time
+		)
+		defer func() { stopTimer(timer) }()
+
+		for {
+			select {
+			case <-pipe.ctx.Done():
+				return pipe.ctx.Err()
+			case <-timerC:
+				timer, timerC = nil, nil
+				if err := emit(pipe.ctx, stopper, output, pending); err != nil {
+					return err
+				}
+			case elem, ok := <-pipe.in:
+				if !ok {
+					if timerC == nil {
+						return nil
+					}
+
+					return emit(pipe.ctx, stopper, output, pending)
+				}
+
+				pending = elem
+				stopTimer(timer)
+				timer = time.NewTimer(quiet)
+				timerC = timer.C
+			}
+		}
+	})
+
+	return Stream[I]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// Delay holds each element for d before emitting it. Elements are delayed one by one,
+// so delays add up: n elements take at least n*d to pass, which also slows down the previous steps.
+// For a delay which does not accumulate, the elements should be spread among parallel steps, like ParMap.
+// If context is cancelled during processing, Delay stops processing and returns error.
+func Delay[I any](pipe Stream[I], d time.Duration, ops ...Option[I]) Stream[I] {
+	output := newOutput(pipe.ctx, ops)
+	stopper := newStopper()
+
+	goStep(pipe.eg, pipe.ctx, "Delay", applyOptions(ops), func() error {
+		defer close(output)
+		defer pipe.stop()
+
+		for elem := range pipe.in {
+			if err := sleep(pipe.ctx, d); err != nil {
+				return err
+			}
+
+			if err := emit(pipe.ctx, stopper, output, elem); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return Stream[I]{
+		in:      output,
+		eg:      pipe.eg,
+		ctx:     pipe.ctx,
+		stopper: stopper,
+	}
+}
+
+// sleep pauses for d or until context is cancelled.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func stopTimer(timer *time.Timer) {
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+type scheduled[I any] struct {
+	elem I
+	at   time.Time
+	seq  int // order of arrival, to keep elements with the same time in order
+}
+
+// scheduleQueue is a min-heap of scheduled elements.
+type scheduleQueue[I any] []scheduled[I]
+
+func (q scheduleQueue[I]) Len() int { return len(q) }
+
+func (q scheduleQueue[I]) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].seq < q[j].seq
+	}
+
+	return q[i].at.Before(q[j].at)
+}
+
+func (q scheduleQueue[I]) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *scheduleQueue[I]) Push(x any) {
+	*q = append(*q, x.(scheduled[I]))
+}
+
+func (q *scheduleQueue[I]) Pop() any {
+	old := *q
+	last := old[len(old)-1]
+	*q = old[:len(old)-1]
+
+	return last
+}
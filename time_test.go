@@ -0,0 +1,437 @@
+package rheos_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dmksnnk/rheos"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
+)
+
+type event struct {
+	ID int
+	At time.Time
+}
+
+func eventTime(e event) time.Time {
+	return e.At
+}
+
+func TestTimeBucket(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("buckets", func(t *testing.T) {
+		events := []event{
+			{ID: 1, At: base},
+			{ID: 2, At: base.Add(30 * time.Second)},
+			{ID: 3, At: base.Add(time.Minute)},
+			{ID: 4, At: base.Add(3*time.Minute + time.Second)},
+			{ID: 5, At: base.Add(3*time.Minute + 59*time.Second)},
+		}
+
+		p := rheos.FromSlice(context.Background(), events)
+		got, err := rheos.Collect(rheos.TimeBucket(p, eventTime, time.Minute))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		want := []rheos.Bucket[event]{
+			{Start: base, Values: events[0:2]},
+			{Start: base.Add(time.Minute), Values: events[2:3]},
+			{Start: base.Add(3 * time.Minute), Values: events[3:5]},
+		}
+		if len(got) != len(want) {
+			t.Fatalf("got %d buckets, want %d", len(got), len(want))
+		}
+		for i := range want {
+			if !got[i].Start.Equal(want[i].Start) {
+				t.Errorf("bucket %d: start %s, want %s", i, got[i].Start, want[i].Start)
+			}
+			assertSlicesEqual(t, want[i].Values, got[i].Values)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		p := rheos.FromSlice(ctx, []event{{ID: 1, At: base}})
+		_, err := rheos.Collect(rheos.TimeBucket(p, eventTime, time.Minute))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestSchedule(t *testing.T) {
+	t.Run("emits in scheduled order", func(t *testing.T) {
+		now := time.Now()
+		events := []event{
+			{ID: 1, At: now.Add(30 * time.Millisecond)},
+			{ID: 2, At: now.Add(10 * time.Millisecond)},
+			{ID: 3, At: now.Add(-time.Hour)}, // in the past
+			{ID: 4, At: now.Add(20 * time.Millisecond)},
+		}
+
+		p := rheos.FromSlice(context.Background(), events)
+		scheduled := rheos.Schedule(p, eventTime)
+		var got []int
+		err := rheos.ForEach(scheduled, func(_ context.Context, e event) error {
+			if time.Now().Before(e.At) {
+				t.Errorf("event %d emitted before its time", e.ID)
+			}
+			got = append(got, e.ID)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{3, 2, 4, 1}, got)
+	})
+
+	t.Run("context cancelled while waiting", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		p := rheos.FromSlice(ctx, []event{{ID: 1, At: time.Now().Add(time.Hour)}})
+		_, err := rheos.Collect(rheos.Schedule(p, eventTime))
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.DeadlineExceeded)
+		}
+	})
+}
+
+func TestReplayTimed(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	events := []event{
+		{ID: 1, At: base},
+		{ID: 2, At: base.Add(40 * time.Millisecond)},
+		{ID: 3, At: base.Add(20 * time.Millisecond)}, // out of order
+		{ID: 4, At: base.Add(40 * time.Millisecond)}, // same time
+		{ID: 5, At: base.Add(80 * time.Millisecond)},
+	}
+
+	t.Run("replays with speed", func(t *testing.T) {
+		start := time.Now()
+		p := rheos.FromSlice(context.Background(), events)
+		replayed := rheos.ReplayTimed(p, eventTime, 2)
+
+		var got []int
+		err := rheos.ForEach(replayed, func(_ context.Context, e event) error {
+			if elapsed, want := time.Since(start), e.At.Sub(base)/2; elapsed < want {
+				t.Errorf("event %d emitted after %s, want at least %s", e.ID, elapsed, want)
+			}
+			got = append(got, e.ID)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{1, 2, 3, 4, 5}, got)
+
+		if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+			t.Errorf("elapsed time %s, want around 40ms", elapsed)
+		}
+	})
+
+	t.Run("context cancelled while waiting", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		p := rheos.FromSlice(ctx, []event{{ID: 1, At: base}, {ID: 2, At: base.Add(time.Hour)}})
+		_, err := rheos.Collect(rheos.ReplayTimed(p, eventTime, 1))
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.DeadlineExceeded)
+		}
+	})
+}
+
+func TestWatchdog(t *testing.T) {
+	t.Run("passes", func(t *testing.T) {
+		num := 10
+		got, err := rheos.Collect(rheos.Watchdog(newProducer(context.Background(), num), 100*time.Millisecond))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(num), got)
+	})
+
+	t.Run("stalled", func(t *testing.T) {
+		stalled := rheos.FromIter(context.Background(), func(yield func(int) bool) error {
+			yield(1)
+			time.Sleep(100 * time.Millisecond) // hang
+			return nil
+		})
+
+		_, err := rheos.Collect(rheos.Watchdog(stalled, 10*time.Millisecond))
+		if !errors.Is(err, rheos.ErrStalled) {
+			t.Errorf("unexpected error: %v, want: %v", err, rheos.ErrStalled)
+		}
+	})
+
+	t.Run("slow consumer is not a stall", func(t *testing.T) {
+		watched := rheos.Watchdog(newProducer(context.Background(), 3), 10*time.Millisecond)
+		err := rheos.ForEach(watched, func(_ context.Context, _ int) error {
+			time.Sleep(20 * time.Millisecond)
+			return nil
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestRate(t *testing.T) {
+	t.Run("reports rate", func(t *testing.T) {
+		num := 100
+		paced := rheos.FromIter(context.Background(), func(yield func(int) bool) error {
+			for i := 0; i < num; i++ {
+				if !yield(i) {
+					return nil
+				}
+				time.Sleep(time.Millisecond)
+			}
+			return nil
+		})
+
+		var reports []float64
+		got, err := rheos.Collect(rheos.Rate(paced, 20*time.Millisecond, func(perSec float64) {
+			reports = append(reports, perSec)
+		}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(num), got)
+
+		if len(reports) == 0 {
+			t.Fatal("rate is not reported")
+		}
+		for _, r := range reports {
+			if r > 2000 { // at most 1 element per millisecond
+				t.Errorf("reported rate %v, want at most around 1000", r)
+			}
+		}
+		if reports[len(reports)/2] < 100 {
+			t.Errorf("reported rate %v, want around 1000", reports[len(reports)/2])
+		}
+	})
+
+	t.Run("invalid window", func(t *testing.T) {
+		_, err := rheos.Collect(rheos.Rate(newProducer(context.Background(), 10), 0, func(float64) {}))
+		if !errors.Is(err, rheos.ErrInvalidWindow) {
+			t.Errorf("unexpected error: %v, want: %v", err, rheos.ErrInvalidWindow)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := rheos.Collect(rheos.Rate(newProducer(ctx, 10), time.Second, func(float64) {}))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestRateLimit(t *testing.T) {
+	t.Run("limits rate", func(t *testing.T) {
+		start := time.Now()
+		got, err := rheos.Collect(rheos.RateLimitEvery(newProducer(context.Background(), 5), 20*time.Millisecond, 1))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(5), got)
+
+		if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+			t.Errorf("elapsed time %s, want at least 80ms", elapsed)
+		}
+	})
+
+	t.Run("shared limiter", func(t *testing.T) {
+		limiter := rate.NewLimiter(rate.Every(20*time.Millisecond), 1)
+		start := time.Now()
+
+		var eg errgroup.Group
+		for i := 0; i < 2; i++ {
+			eg.Go(func() error {
+				_, err := rheos.Collect(rheos.RateLimit(newProducer(context.Background(), 3), limiter))
+				return err
+			})
+		}
+		if err := eg.Wait(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+			t.Errorf("elapsed time %s, want at least 100ms for 6 elements", elapsed)
+		}
+	})
+
+	t.Run("zero burst", func(t *testing.T) {
+		_, err := rheos.Collect(rheos.RateLimitEvery(newProducer(context.Background(), 5), time.Millisecond, 0))
+		if !errors.Is(err, rheos.ErrRateLimit) {
+			t.Errorf("unexpected error: %v, want: %v", err, rheos.ErrRateLimit)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, err := rheos.Collect(rheos.RateLimitEvery(newProducer(ctx, 5), time.Hour, 1))
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.DeadlineExceeded)
+		}
+	})
+}
+
+func TestThrottle(t *testing.T) {
+	t.Run("spaces out elements", func(t *testing.T) {
+		var received []time.Time
+		err := rheos.ForEach(rheos.Throttle(newProducer(context.Background(), 5), 20*time.Millisecond), func(context.Context, int) error {
+			received = append(received, time.Now())
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		for i := 1; i < len(received); i++ {
+			if gap := received[i].Sub(received[i-1]); gap < 15*time.Millisecond { // minus scheduling jitter
+				t.Errorf("gap between elements %d and %d is %s, want about 20ms", i-1, i, gap)
+			}
+		}
+	})
+
+	t.Run("slow input is not delayed", func(t *testing.T) {
+		slow := rheos.Map(newProducer(context.Background(), 5), func(_ context.Context, v int) (int, error) {
+			time.Sleep(20 * time.Millisecond)
+			return v, nil
+		})
+		start := time.Now()
+		got, err := rheos.Collect(rheos.Throttle(slow, 10*time.Millisecond))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(5), got)
+
+		if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+			t.Errorf("elapsed time %s, want about 100ms", elapsed)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, err := rheos.Collect(rheos.Throttle(newProducer(ctx, 5), time.Hour))
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.DeadlineExceeded)
+		}
+	})
+}
+
+func TestDebounce(t *testing.T) {
+	bursts := func(ctx context.Context, bursts ...[]int) rheos.Stream[int] {
+		return rheos.FromIter(ctx, func(yield func(int) bool) error {
+			for i, burst := range bursts {
+				if i > 0 {
+					time.Sleep(50 * time.Millisecond)
+				}
+				for _, v := range burst {
+					if !yield(v) {
+						return nil
+					}
+				}
+			}
+			return nil
+		})
+	}
+
+	t.Run("emits last of each burst", func(t *testing.T) {
+		input := bursts(context.Background(), []int{1, 2, 3}, []int{4, 5}, []int{6})
+		got, err := rheos.Collect(rheos.Debounce(input, 20*time.Millisecond))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{3, 5, 6}, got)
+	})
+
+	t.Run("flushes pending on end", func(t *testing.T) {
+		start := time.Now()
+		got, err := rheos.Collect(rheos.Debounce(newProducer(context.Background(), 5), time.Hour))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{4}, got)
+
+		if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+			t.Errorf("elapsed time %s, want pending element flushed without waiting", elapsed)
+		}
+	})
+
+	t.Run("empty input", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.Debounce(newProducer(context.Background(), 0), time.Hour))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, []int{}, got)
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		input := rheos.FromIter(ctx, func(yield func(int) bool) error {
+			yield(1)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		_, err := rheos.Collect(rheos.Debounce(input, time.Hour))
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.DeadlineExceeded)
+		}
+	})
+}
+
+func TestDelay(t *testing.T) {
+	t.Run("delays each element", func(t *testing.T) {
+		start := time.Now()
+		var received []time.Duration
+		err := rheos.ForEach(rheos.Delay(newProducer(context.Background(), 4), 20*time.Millisecond), func(context.Context, int) error {
+			received = append(received, time.Since(start))
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		for i, elapsed := range received {
+			if want := time.Duration(i+1) * 20 * time.Millisecond; elapsed < want {
+				t.Errorf("element %d received after %s, want at least %s", i, elapsed, want)
+			}
+		}
+	})
+
+	t.Run("keeps elements", func(t *testing.T) {
+		got, err := rheos.Collect(rheos.Delay(newProducer(context.Background(), 10), time.Microsecond))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertSlicesEqual(t, intRange(10), got)
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, err := rheos.Collect(rheos.Delay(newProducer(ctx, 5), time.Hour))
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("unexpected error: %v, want: %v", err, context.DeadlineExceeded)
+		}
+	})
+}